@@ -0,0 +1,291 @@
+package dot
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/store"
+	"github.com/opnlabs/dot/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	keepRuns        int
+	keepOlderThan   time.Duration
+	artifactsRun    string
+	outputDir       string
+	artifactsConfig string
+	artifactsIndex  string
+	s3Config        artifacts.S3Config
+)
+
+var artifactsCmd = &cobra.Command{
+	Use:   "artifacts",
+	Short: "Manages the artifacts of earlier runs",
+	Long: `Manages the artifacts stored in --artifacts-dir, or in the bucket given with --artifacts-bucket.
+Every run records the artifacts it can use in a manifest, and the contents are stored once no matter how
+many runs use them.`,
+}
+
+var artifactsRunsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "Lists the runs that have artifacts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := openRepository().Runs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(runs) == 0 {
+			fmt.Println("no runs")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "RUN\tCREATED\tARTIFACTS")
+		for _, v := range runs {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", v.RunID, v.Created.Local().Format(time.DateTime), len(v.Artifacts))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var artifactsLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists the artifacts of a run",
+	Long:  `Lists the artifacts of the run selected with --run, the latest run by default.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m, err := openRepository().Manifest(artifactsRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("run %s\n", m.RunID)
+		if len(m.Artifacts) == 0 {
+			fmt.Println("no artifacts")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tJOB\tPATH\tSIZE\tSHA256\tRUN")
+		for _, v := range m.Artifacts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", orNone(v.Name), v.Job, v.Path, formatSize(v.Size), shortSHA(v.SHA256), v.RunID)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var artifactsGetCmd = &cobra.Command{
+	Use:   "get <name>...",
+	Short: "Extracts artifacts",
+	Long: `Extracts the artifacts with the given names, or published by the given jobs, into the directory
+given with -o. Every artifact is extracted under its base name, like dist for an artifact published from dist.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := openRepository()
+		m, err := repo.Manifest(artifactsRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		selected := m.Select(args)
+		if len(selected) == 0 {
+			log.Fatalf("run %s has no artifacts named %s", m.RunID, strings.Join(args, ", "))
+		}
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+
+		for _, v := range selected {
+			r, err := repo.Open(v.SHA256)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = utils.ExtractTar(r, outputDir)
+			r.Close()
+			if err != nil {
+				log.Fatalf("could not extract artifact %s of job %s: %v", v.Path, v.Job, err)
+			}
+			fmt.Printf("extracted %s of job %s to %s\n", v.Path, v.Job, filepath.Join(outputDir, path.Base(utils.GlobBase(v.Path))))
+		}
+	},
+}
+
+var artifactsDiffCmd = &cobra.Command{
+	Use:   "diff <run> <run>",
+	Short: "Compares the artifacts of two runs",
+	Long: `Compares the files in the artifacts of two runs. Files are matched by the name of the artifact and
+their path inside it. The run ids can be found with dot artifacts runs, and latest is the latest run.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		changes, err := openRepository().Diff(args[0], args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(changes) == 0 {
+			fmt.Println("no differences")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "CHANGE\tARTIFACT\tFILE\tSIZE\tSHA256")
+		for _, v := range changes {
+			switch v.Kind {
+			case artifacts.Added:
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Kind, v.Artifact, v.File, formatSize(v.New.Size), shortSHA(v.New.SHA256))
+			case artifacts.Removed:
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Kind, v.Artifact, v.File, formatSize(v.Old.Size), shortSHA(v.Old.SHA256))
+			default:
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s -> %s\t%s -> %s\n", v.Kind, v.Artifact, v.File,
+					formatSize(v.Old.Size), formatSize(v.New.Size), shortSHA(v.Old.SHA256), shortSHA(v.New.SHA256))
+			}
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var artifactsGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Removes old runs and the artifacts only they use",
+	Long: `Removes the runs after the --keep most recent ones and the runs older than --older-than, then
+deletes the artifacts that no remaining run uses. Do not run it while a pipeline is running.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if keepRuns <= 0 && keepOlderThan <= 0 {
+			log.Fatal("set --keep or --older-than to choose the runs to remove")
+		}
+
+		result, err := openRepository().GC(keepRuns, keepOlderThan)
+		for _, v := range result.Runs {
+			fmt.Printf("removed run %s\n", v)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("removed %d runs, freed %s\n", len(result.Runs), formatSize(result.Freed))
+	},
+}
+
+func init() {
+	addArtifactsFlags(artifactsCmd.PersistentFlags())
+	artifactsGCCmd.Flags().IntVar(&keepRuns, "keep", 0, "Number of most recent runs to keep.")
+	artifactsGCCmd.Flags().DurationVar(&keepOlderThan, "older-than", 0, "Remove the runs older than this duration, like 168h.")
+	for _, v := range []*cobra.Command{artifactsLsCmd, artifactsGetCmd} {
+		v.Flags().StringVar(&artifactsRun, "run", artifacts.LatestRun, "Run of the artifacts.")
+	}
+	artifactsGetCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory the artifacts are extracted to.")
+	artifactsCmd.AddCommand(artifactsRunsCmd)
+	artifactsCmd.AddCommand(artifactsLsCmd)
+	artifactsCmd.AddCommand(artifactsGetCmd)
+	artifactsCmd.AddCommand(artifactsDiffCmd)
+	artifactsCmd.AddCommand(artifactsGCCmd)
+}
+
+// addArtifactsFlags adds the flags that select where the artifacts are stored.
+func addArtifactsFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&artifactsDir, "artifacts-dir", ".artifacts", "Directory where the artifacts of the runs are stored.")
+	flags.StringVar(&artifactsConfig, "artifacts-config", "", "YAML file with the endpoint, bucket, prefix, region, insecure, access_key_id and secret_access_key of the S3 bucket of the artifacts. Flags override its values.")
+	flags.StringVar(&s3Config.Bucket, "artifacts-bucket", "", "Store the artifacts in this S3 bucket instead of --artifacts-dir.")
+	flags.StringVar(&s3Config.Prefix, "artifacts-prefix", "", "Prefix of the artifacts in --artifacts-bucket.")
+	flags.StringVar(&s3Config.Endpoint, "s3-endpoint", "", "Host and port of the S3 API. Defaults to s3.amazonaws.com.")
+	flags.StringVar(&s3Config.Region, "s3-region", "", "Region of --artifacts-bucket.")
+	flags.BoolVar(&s3Config.Insecure, "s3-insecure", false, "Use http to connect to --s3-endpoint.")
+}
+
+// loadS3Config merges the flags with the values of --artifacts-config. The bucket is empty if the
+// artifacts are stored in --artifacts-dir.
+func loadS3Config() (artifacts.S3Config, error) {
+	cfg := s3Config
+	if len(artifactsConfig) > 0 {
+		f, err := os.Open(artifactsConfig)
+		if err != nil {
+			return cfg, fmt.Errorf("could not open artifacts config: %v", err)
+		}
+		defer f.Close()
+
+		var file artifacts.S3Config
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil {
+			return cfg, fmt.Errorf("could not read artifacts config %s: %v", artifactsConfig, err)
+		}
+		for _, v := range []struct{ flag, file *string }{
+			{&cfg.Endpoint, &file.Endpoint},
+			{&cfg.Bucket, &file.Bucket},
+			{&cfg.Prefix, &file.Prefix},
+			{&cfg.Region, &file.Region},
+			{&cfg.AccessKeyID, &file.AccessKeyID},
+			{&cfg.SecretAccessKey, &file.SecretAccessKey},
+		} {
+			if len(*v.flag) == 0 {
+				*v.flag = *v.file
+			}
+		}
+		cfg.Insecure = cfg.Insecure || file.Insecure
+	}
+	if len(cfg.Endpoint) == 0 {
+		cfg.Endpoint = "s3.amazonaws.com"
+	}
+	return cfg, nil
+}
+
+// openRepository opens the repository in the S3 bucket selected by the flags, or in --artifacts-dir.
+func openRepository() *artifacts.Repository {
+	cfg, err := loadS3Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Bucket) > 0 {
+		backend, err := artifacts.NewS3Backend(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return artifacts.NewBackendRepository(backend)
+	}
+
+	repo, err := artifacts.NewRepository(artifactsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return repo
+}
+
+// openArtifactsIndex opens the store of the artifact keys, in --artifacts-index if it is set, and
+// returns the function that closes it.
+func openArtifactsIndex() (store.Store, func(), error) {
+	if len(artifactsIndex) == 0 {
+		return store.NewMemStore(store.Isolated()), func() {}, nil
+	}
+
+	s, err := store.NewFileStore(artifactsIndex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open artifacts index %s: %v", artifactsIndex, err)
+	}
+	return s, func() {
+		if err := s.Close(); err != nil {
+			log.Printf("could not close artifacts index %s: %v", artifactsIndex, err)
+		}
+	}, nil
+}
+
+// shortSHA returns the first 12 characters of a sha256, or all of it if it is shorter.
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
@@ -0,0 +1,146 @@
+package dot
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/cache"
+	"github.com/opnlabs/dot/pkg/engine"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	cacheDir    string
+	cacheVolume string
+	cacheImage  string
+	noCache     bool
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manages the cache of the jobs",
+	Long: `Manages the files that jobs keep between runs with the cache section. The cache is stored in a
+directory on the host, or in a volume of the container engine with --cache-volume.`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists the cache entries",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, closeCache, err := openCache()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeCache()
+
+		entries, err := c.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(entries) == 0 {
+			fmt.Println("the cache is empty")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "KEY\tSIZE\tCREATED\tPATHS")
+		for _, v := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Key, formatSize(v.Size), v.Created.Local().Format(time.DateTime), strings.Join(v.Paths, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [key prefix...]",
+	Short: "Removes cache entries",
+	Long:  `Removes the cache entries whose key starts with one of the prefixes, or the whole cache without arguments.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, closeCache, err := openCache()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeCache()
+
+		if len(args) == 0 {
+			if err := c.Clear(); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("cleared the cache")
+			return
+		}
+
+		removed := 0
+		for _, prefix := range args {
+			entries, err := c.Remove(prefix)
+			for _, v := range entries {
+				fmt.Printf("removed %s\n", v.Key)
+			}
+			removed += len(entries)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if removed == 0 {
+			fmt.Println("no cache entries to remove")
+		}
+	},
+}
+
+func init() {
+	addCacheFlags(cacheCmd.PersistentFlags())
+	cacheCmd.PersistentFlags().StringVar(&containerEngine, "engine", string(engine.Docker), "Container engine used with --cache-volume. One of docker, podman or auto.")
+	cacheCmd.AddCommand(cacheLsCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
+
+// addCacheFlags adds the flags that select where the cache is stored.
+func addCacheFlags(flags *pflag.FlagSet) {
+	dir := ".dot-cache"
+	if v, err := os.UserCacheDir(); err == nil {
+		dir = filepath.Join(v, "dot")
+	}
+	flags.StringVar(&cacheDir, "cache-dir", dir, "Directory where the cache of the jobs is stored.")
+	flags.StringVar(&cacheVolume, "cache-volume", "", "Store the cache in this volume of the container engine instead of --cache-dir.")
+	flags.StringVar(&cacheImage, "cache-image", "docker.io/library/busybox:latest", "Image of the helper container that accesses --cache-volume.")
+}
+
+// openCache opens the cache selected by the flags. The returned function releases the resources used
+// to access the cache.
+func openCache() (*cache.Cache, func(), error) {
+	if len(cacheVolume) == 0 {
+		return cache.New(cache.NewDirBackend(cacheDir)), func() {}, nil
+	}
+
+	backend, err := cache.NewVolumeBackend(engine.Engine(containerEngine), cacheVolume, cacheImage)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cache.New(backend), func() {
+		if err := backend.Close(); err != nil {
+			log.Printf("could not remove the cache helper container: %v", err)
+		}
+	}, nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
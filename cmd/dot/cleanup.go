@@ -0,0 +1,34 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/opnlabs/dot/pkg/engine"
+	"github.com/opnlabs/dot/pkg/runner"
+	"github.com/spf13/cobra"
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Removes containers left behind by dot",
+	Long: `Removes the containers created by dot that were not removed, for example because dot was killed.
+Containers are found by the ` + runner.CONTAINER_LABEL + ` label. Containers of a dot run in progress are removed too.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		removed, err := runner.RemoveContainers(context.Background(), engine.Engine(containerEngine))
+		for _, v := range removed {
+			fmt.Printf("removed %s\n", v)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(removed) == 0 {
+			fmt.Println("no containers to remove")
+		}
+	},
+}
+
+func init() {
+	cleanupCmd.Flags().StringVar(&containerEngine, "engine", string(engine.Docker), "Container engine. One of docker, podman or auto.")
+}
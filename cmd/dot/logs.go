@@ -0,0 +1,113 @@
+package dot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/pipeline"
+	"github.com/opnlabs/dot/pkg/runner"
+	"github.com/opnlabs/dot/pkg/utils"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	logFormat  string
+	timestamps string
+	// jsonLog is set when the logs are written as JSON.
+	jsonLog *utils.JSONLog
+	// logStart is the time elapsed timestamps are measured from.
+	logStart time.Time
+)
+
+// setupLogs checks the --log-format and --timestamps flags. With json, the messages of dot are written
+// to stdout as system records from now on.
+func setupLogs() error {
+	logStart = time.Now()
+	switch utils.Timestamps(timestamps) {
+	case utils.TimestampsNone, utils.TimestampsElapsed, utils.TimestampsWall:
+	default:
+		return fmt.Errorf("unknown timestamps %s, expected %s, %s or %s", timestamps, utils.TimestampsNone, utils.TimestampsElapsed, utils.TimestampsWall)
+	}
+
+	switch logFormat {
+	case logFormatText:
+	case logFormatJSON:
+		jsonLog = utils.NewJSONLog(os.Stdout)
+		log.SetFlags(0)
+		log.SetOutput(jsonLog.Writer(utils.LogRecord{Stream: utils.StreamSystem}))
+	default:
+		return fmt.Errorf("unknown log format %s, expected %s or %s", logFormat, logFormatText, logFormatJSON)
+	}
+	return nil
+}
+
+// jobLogs returns the writers of an attempt of the job and the function that flushes them once it is
+// done. Events are only reported in the JSON logs, which carry their own timestamps.
+func jobLogs(job models.Job, attempt int) (stdout, stderr io.Writer, events runner.Events, done func()) {
+	if jsonLog == nil {
+		label := attemptLabel(job, attempt)
+		outLogger := utils.NewColorLogger(label, os.Stdout, true).WithTimestamps(utils.Timestamps(timestamps), logStart)
+		errLogger := utils.NewColorLogger(label, os.Stderr, false).WithTimestamps(utils.Timestamps(timestamps), logStart)
+		return outLogger, errLogger, nil, func() {
+			outLogger.Close()
+			errLogger.Close()
+		}
+	}
+
+	record := utils.LogRecord{Job: job.Name, Attempt: attempt, Stage: string(job.Stage)}
+	out, errOut := record, record
+	out.Stream, errOut.Stream = utils.StreamStdout, utils.StreamStderr
+	outWriter, errWriter := jsonLog.Writer(out), jsonLog.Writer(errOut)
+	return outWriter, errWriter, jobEvents(job, attempt), func() {
+		outWriter.Close()
+		errWriter.Close()
+	}
+}
+
+// jobEvents returns the events of an attempt of the job, which are written as system records. It
+// returns nil unless the logs are written as JSON.
+func jobEvents(job models.Job, attempt int) runner.Events {
+	if jsonLog == nil {
+		return nil
+	}
+	return func(event runner.Event, message string) {
+		jsonLog.Log(utils.LogRecord{
+			Job:     job.Name,
+			Stream:  utils.StreamSystem,
+			Attempt: attempt,
+			Stage:   string(job.Stage),
+			Event:   string(event),
+			Message: message,
+		})
+	}
+}
+
+// writeSummary prints the results of the jobs and the status of the run.
+func writeSummary(summary *pipeline.Summary, runID string) {
+	status := fmt.Sprintf("pipeline %s, run %s", summary.Status(), runID)
+	if jsonLog == nil {
+		fmt.Println()
+		if err := summary.Write(os.Stdout); err != nil {
+			log.Print(err)
+		}
+		fmt.Printf("\n%s\n", status)
+		return
+	}
+
+	for _, r := range summary.Results() {
+		message := string(r.Status)
+		if len(r.Reason) > 0 {
+			message += ": " + r.Reason
+		}
+		jsonLog.Log(utils.LogRecord{Job: r.Job, Stream: utils.StreamSystem, Attempt: r.Attempts, Event: "job_finished", Message: message})
+	}
+	jsonLog.Log(utils.LogRecord{Stream: utils.StreamSystem, Event: "pipeline_finished", Message: status})
+}
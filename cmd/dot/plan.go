@@ -0,0 +1,195 @@
+package dot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/runner"
+	"github.com/spf13/cobra"
+)
+
+// secretRegexp matches the names of variables whose values are masked in the plan.
+var secretRegexp = regexp.MustCompile(`(?i)secret|token|passw(or)?d|pass|key|credential|auth`)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Prints the execution plan without running any job",
+	Long: `Prints the execution plan for the job file ( default dot.yml ) without running any job.
+The plan shows the jobs in each stage with their image, entrypoint, command, variables and artifacts,
+and the jobs that are skipped with the reason. Values of variables that look like secrets are masked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := parseVariables(); err != nil {
+			log.Fatal(err)
+		}
+		plan(os.Stdout)
+	},
+}
+
+func init() {
+	addPipelineFlags(planCmd.Flags())
+}
+
+func plan(out io.Writer) {
+	jobFile, err := loadJobFile(jobFilePath, environmentVariables)
+	if err != nil {
+		log.Fatalf("Err(s):\n%v\n", err)
+	}
+
+	graph, skipped, err := resolvePipeline(jobFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runners := newRegistry(nil)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, stage := range jobFile.Stages {
+		fmt.Fprintf(w, "Stage %s\n", stage)
+		empty := true
+		for _, job := range graph.Jobs() {
+			if job.Stage != stage {
+				continue
+			}
+			empty = false
+
+			name, err := runners.Resolve(job)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			image := job.Image
+			if name == "shell" {
+				image = "none, runs on the host"
+			}
+			entrypoint := "image default"
+			if len(job.Entrypoint) > 0 {
+				entrypoint = formatCommand(job.Entrypoint)
+			}
+			after := "jobs in earlier stages"
+			if job.Needs != nil {
+				after = orNone(strings.Join(graph.Dependencies(job.Name), ", "))
+			}
+
+			fmt.Fprintf(w, "  %s\n", job.Name)
+			fmt.Fprintf(w, "    runner\t%s\n", name)
+			fmt.Fprintf(w, "    image\t%s\n", image)
+			fmt.Fprintf(w, "    entrypoint\t%s\n", entrypoint)
+			fmt.Fprintf(w, "    command\t%s\n", formatCommand(runner.Command(job.Entrypoint, job.Script)))
+			fmt.Fprintf(w, "    after\t%s\n", after)
+			fmt.Fprintf(w, "    timeout\t%s\n", jobTimeout(job, jobFile))
+			if len(job.When) > 0 {
+				fmt.Fprintf(w, "    when\t%s\n", job.When)
+			}
+			if job.AllowFailure.Enabled {
+				allowed := "any failure"
+				if len(job.AllowFailure.ExitCodes) > 0 {
+					allowed = fmt.Sprintf("exit codes %v", job.AllowFailure.ExitCodes)
+				}
+				fmt.Fprintf(w, "    allow failure\t%s\n", allowed)
+			}
+			if job.Retry != nil {
+				fmt.Fprintf(w, "    retry\t%s\n", formatRetry(job.Retry))
+			}
+			fmt.Fprintf(w, "    env\t%s\n", orNone(formatEnv(append(append([]models.Variable{}, job.Variables...), environmentVariables...))))
+			fmt.Fprintf(w, "    artifacts\t%s\n", orNone(formatArtifacts(job)))
+			if job.Dependencies != nil {
+				fmt.Fprintf(w, "    dependencies\t%s\n", orNone(strings.Join(job.Dependencies, ", ")))
+			}
+			if job.Cache != nil && name == "docker" {
+				fmt.Fprintf(w, "    cache\t%s, key %s\n", strings.Join(job.Cache.Paths, ", "), job.Cache.Key)
+			}
+			for _, v := range job.Services {
+				fmt.Fprintf(w, "    service\t%s as %s, env %s\n", v.Image, v.Hostname(), orNone(formatEnv(v.Variables)))
+			}
+		}
+		if empty {
+			fmt.Fprintln(w, "  no jobs")
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintln(w, "Skipped")
+		for _, v := range skipped {
+			fmt.Fprintf(w, "  %s\t%s\n", v.Job.Name, v.Reason)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// formatArtifacts prints the paths of the artifacts and the name of those not named after the job.
+func formatArtifacts(job models.Job) string {
+	paths := make([]string, 0, len(job.Artifacts))
+	for _, v := range job.Artifacts {
+		if len(v.Name) > 0 && v.Name != job.Name {
+			paths = append(paths, fmt.Sprintf("%s as %s", v.Path, v.Name))
+			continue
+		}
+		paths = append(paths, v.Path)
+	}
+	return strings.Join(paths, ", ")
+}
+
+func formatCommand(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, v := range args {
+		if strings.ContainsAny(v, " \t\n\"'") {
+			v = strconv.Quote(v)
+		}
+		quoted = append(quoted, v)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// formatEnv prints the variables as KEY=VALUE, masking the values of secrets. A variable defined
+// more than once is printed with the last value, which is the one the job sees.
+func formatEnv(env []models.Variable) string {
+	keys := make([]string, 0, len(env))
+	values := make(map[string]any)
+	for _, v := range env {
+		for k, value := range v {
+			if _, ok := values[k]; !ok {
+				keys = append(keys, k)
+			}
+			values[k] = value
+		}
+	}
+
+	entries := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := values[k]
+		if secretRegexp.MatchString(k) {
+			value = "********"
+		}
+		entries = append(entries, fmt.Sprintf("%s=%v", k, value))
+	}
+	return strings.Join(entries, ", ")
+}
+
+func formatRetry(r *models.Retry) string {
+	on := "any failure"
+	if len(r.On) > 0 {
+		on = strings.Join(r.On, ", ")
+	}
+	s := fmt.Sprintf("%d attempts, backoff %s, on %s", r.Attempts, r.Backoff, on)
+	if len(r.ExitCodes) > 0 {
+		s += fmt.Sprintf(", exit codes %v", r.ExitCodes)
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+	return s
+}
@@ -2,21 +2,25 @@ package dot
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/expr-lang/expr"
-	"github.com/go-playground/validator/v10"
 	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/cache"
+	"github.com/opnlabs/dot/pkg/engine"
 	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/pipeline"
 	"github.com/opnlabs/dot/pkg/runner"
+	"github.com/opnlabs/dot/pkg/store"
 	"github.com/opnlabs/dot/pkg/utils"
 	"github.com/spf13/cobra"
-	"golang.org/x/sync/errgroup"
-	"gopkg.in/yaml.v3"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -26,44 +30,177 @@ var (
 	environmentVariables []models.Variable = make([]models.Variable, 0)
 	username             string
 	password             string
-	validate             *validator.Validate = validator.New(validator.WithRequiredStructEnabled())
+	defaultRunner        string
+	shellInPlace         bool
+	containerEngine      string
+	onlyJobs             []string
+	onlyStages           []string
+	fromStage            string
+	withProducers        bool
+	dryRun               bool
+	gracePeriod          time.Duration
+	defaultTimeout       time.Duration
+	artifactsDir         string
+	fromRun              string
 )
 
+// exitCanceled is the exit code when the run is interrupted by a signal, following the shell
+// convention for SIGINT.
+const exitCanceled = 130
+
 var rootCmd = &cobra.Command{
 	Use:   "dot",
 	Short: "Dot is a minimal CI",
 	Long: `Dot is a minimal CI that runs jobs defined in a file ( default dot.yml )
 inside docker containers. Jobs can be divided into stages where jobs within a stage are executed
-concurrently.`,
+concurrently. Jobs that declare needs start as soon as the jobs they need succeed.`,
 
 	Run: func(cmd *cobra.Command, args []string) {
-
-		if len(envVars) > 0 {
-			for _, v := range envVars {
-				variables := strings.Split(v, "=")
-				if len(variables) != 2 {
-					log.Fatalf("variables should be defined as KEY=VALUE: %s", v)
-				}
-
-				m := make(map[string]any)
-				m[variables[0]] = variables[1]
-				environmentVariables = append(environmentVariables, m)
-			}
+		if err := parseVariables(); err != nil {
+			log.Fatal(err)
 		}
 
+		if dryRun {
+			plan(os.Stdout)
+			return
+		}
+		if err := setupLogs(); err != nil {
+			log.Fatal(err)
+		}
 		run()
 	},
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&jobFilePath, "job-file-path", "f", "dot.yml", "Path to the job file.")
-	rootCmd.Flags().BoolVarP(&mountDockerSocket, "mount-docker-socket", "m", false, "Mount docker socket. Required to run containers from dot.")
+	addPipelineFlags(rootCmd.Flags())
+	rootCmd.Flags().BoolVarP(&mountDockerSocket, "mount-docker-socket", "m", false, "Mount docker socket. Required to run containers from dot. With podman, the podman socket is mounted at the docker socket path.")
 	rootCmd.Flags().StringVarP(&username, "registry-username", "u", "", "Username for the container registry")
 	rootCmd.Flags().StringVarP(&password, "registry-password", "p", "", "Password / Token for the container registry")
 
-	rootCmd.Flags().StringArrayVarP(&envVars, "environment-variable", "e", make([]string, 0), "Environment variables. KEY=VALUE")
+	rootCmd.Flags().StringVar(&containerEngine, "engine", string(engine.Docker), "Container engine used by the docker runner. One of docker, podman or auto.")
+	rootCmd.Flags().DurationVar(&gracePeriod, "grace-period", 10*time.Second, "Time given to running jobs to exit when dot is interrupted, before they are killed.")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the execution plan without running any job. Same as dot plan.")
+	rootCmd.Flags().StringVar(&logFormat, "log-format", logFormatText, "Format of the logs. One of text or json, which writes one JSON object per line to stdout.")
+	rootCmd.Flags().StringVar(&timestamps, "timestamps", string(utils.TimestampsNone), "Time printed before every line of the jobs. One of none, elapsed since the start of the run or wall for the time of day.")
+	rootCmd.Flags().BoolVar(&shellInPlace, "shell-in-place", false, "Run shell jobs directly in their src directory instead of a temporary copy of it.")
+	addArtifactsFlags(rootCmd.Flags())
+	rootCmd.Flags().StringVar(&artifactsIndex, "artifacts-index", "", "File that records the keys of the published artifacts, so other dot processes can read them. They are kept in memory by default.")
+	rootCmd.Flags().StringVar(&fromRun, "from-run", "", "Reuse the artifacts of an earlier run, or of the latest run with latest, for the jobs that do not run.")
+	rootCmd.Flags().BoolVar(&noCache, "no-cache", false, "Run the jobs without restoring or saving their cache.")
+	addCacheFlags(rootCmd.Flags())
 
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(artifactsCmd)
+}
+
+// addPipelineFlags adds the flags that decide which jobs run and how. They are shared by the commands
+// that resolve the pipeline.
+func addPipelineFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&jobFilePath, "job-file-path", "f", "dot.yml", "Path to the job file.")
+	flags.StringArrayVarP(&envVars, "environment-variable", "e", make([]string, 0), "Environment variables. KEY=VALUE")
+	flags.StringVarP(&defaultRunner, "runner", "r", "docker", "Runner used for jobs that do not specify one.")
+	flags.StringArrayVarP(&onlyJobs, "job", "j", make([]string, 0), "Run only the named job. Can be repeated.")
+	flags.StringArrayVarP(&onlyStages, "stage", "s", make([]string, 0), "Run only the jobs in the stage. Can be repeated.")
+	flags.StringVar(&fromStage, "from-stage", "", "Run the jobs in the stage and all the stages after it.")
+	flags.DurationVar(&defaultTimeout, "timeout", 0, "Timeout of the jobs that do not set one. Overrides the timeout of the job file. Defaults to 1h.")
+	flags.BoolVar(&withProducers, "with-producers", false, "Also run the upstream jobs that publish artifacts used by the selected jobs.")
+}
+
+// parseVariables converts the KEY=VALUE variables from the command line. Values are kept as strings.
+func parseVariables() error {
+	for _, v := range envVars {
+		variables := strings.Split(v, "=")
+		if len(variables) != 2 {
+			return fmt.Errorf("variables should be defined as KEY=VALUE: %s", v)
+		}
+
+		m := make(map[string]any)
+		m[variables[0]] = variables[1]
+		environmentVariables = append(environmentVariables, m)
+	}
+	return nil
+}
+
+// newRegistry registers the available runners. Creating the registry does not connect to any engine.
+// Docker jobs run without their cache if c is nil.
+func newRegistry(c *cache.Cache) *runner.Registry {
+	runners := runner.NewRegistry(defaultRunner)
+	runners.Register("docker", runner.NewDockerFactory(runner.DockerRunnerOptions{
+		ShowImagePull:     jsonLog == nil,
+		MountDockerSocket: mountDockerSocket,
+		Engine:            engine.Engine(containerEngine),
+		StopTimeout:       gracePeriod,
+		Cache:             c,
+	}, username, password))
+	runners.Register("shell", runner.NewShellFactory(runner.ShellRunnerOptions{
+		InPlace:     shellInPlace,
+		StopTimeout: gracePeriod,
+	}))
+	return runners
+}
+
+// resolvePipeline expands the jobs, evaluates their conditions and applies the job and stage filters.
+// It returns the graph of jobs to run and the jobs that were skipped.
+func resolvePipeline(jobFile models.JobFile) (*pipeline.Graph, []pipeline.Skipped, error) {
+	stageMap := make(map[models.Stage][]models.Job)
+	for _, v := range jobFile.Stages {
+		stageMap[v] = make([]models.Job, 0)
+	}
+
+	expandedJobs, err := pipeline.ExpandMatrix(jobFile.Jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skipped := make([]pipeline.Skipped, 0)
+	for _, v := range expandedJobs {
+		if _, ok := stageMap[v.Stage]; !ok {
+			return nil, nil, fmt.Errorf("stage not defined: %s", v.Stage)
+		}
+
+		ok, err := pipeline.Evaluate(v, environmentVariables)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Only append to stageMap if the condition evaluates to true
+		if ok {
+			stageMap[v.Stage] = append(stageMap[v.Stage], v)
+		} else {
+			skipped = append(skipped, pipeline.Skipped{Job: v, Reason: fmt.Sprintf("condition %s evaluated to false", v.Condition)})
+		}
+	}
+
+	jobs := make([]models.Job, 0)
+	for _, v := range jobFile.Stages {
+		jobs = append(jobs, stageMap[v]...)
+	}
+
+	graph, err := pipeline.NewGraph(jobFile.Stages, jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stages := make([]models.Stage, 0, len(onlyStages))
+	for _, v := range onlyStages {
+		stages = append(stages, models.Stage(v))
+	}
+	graph, filtered, err := graph.Select(pipeline.Filter{
+		Jobs:          onlyJobs,
+		Stages:        stages,
+		FromStage:     models.Stage(fromStage),
+		WithProducers: withProducers,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return graph, append(skipped, filtered...), nil
 }
 
 // Execute runs the root command for dot.
@@ -74,93 +211,159 @@ func Execute() {
 }
 
 func run() {
-	ctx := context.Background()
-	contents, err := os.ReadFile(filepath.Clean(jobFilePath))
+	jobFile, err := loadJobFile(jobFilePath, environmentVariables)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Err(s):\n%v\n", err)
 	}
 
-	var jobFile models.JobFile
-	err = yaml.Unmarshal(contents, &jobFile)
+	graph, skipped, err := resolvePipeline(jobFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	err = validate.Struct(jobFile)
-	if err != nil {
-		log.Fatalf("Err(s):\n%+v\n", err)
+	for _, v := range skipped {
+		log.Printf("skipping job %s: %s", v.Job.Name, v.Reason)
 	}
 
-	stageMap := make(map[models.Stage][]models.Job)
-	for _, v := range jobFile.Stages {
-		stageMap[v] = make([]models.Job, 0)
+	if _, err := engine.Host(engine.Engine(containerEngine)); err != nil {
+		log.Fatal(err)
 	}
 
-	for _, v := range jobFile.Jobs {
-		if _, ok := stageMap[v.Stage]; !ok {
-			log.Fatalf("stage not defined: %s", v.Stage)
-		}
-
-		// Create expr program with the variables passed as env
-		if len(v.Condition) == 0 {
-			v.Condition = `true`
+	var jobCache *cache.Cache
+	if !noCache {
+		c, closeCache, err := openCache()
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer closeCache()
+		jobCache = c
+	}
 
-		env := make(map[string]any)
-		for _, entries := range v.Variables {
-			if len(entries) > 1 {
-				log.Fatal("variables should be defined as a key value pair")
-			}
-			for k, value := range entries {
-				env[k] = value
-			}
+	runners := newRegistry(jobCache)
+	for _, job := range graph.Jobs() {
+		if _, err := runners.Resolve(job); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		p, err := expr.Compile(v.Condition, expr.Env(env), expr.AsBool())
+	// Both managers record their artifacts in the same run so jobs can mix runners.
+	pipelineRun, err := openRepository().NewRun(artifacts.NewRunID())
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("starting run %s", pipelineRun.ID())
+	if len(fromRun) > 0 {
+		m, err := pipelineRun.Import(fromRun)
 		if err != nil {
-			log.Fatalf("condition evaluation failed for job %s: %v", v.Name, err)
+			log.Fatal(err)
 		}
-		output, err := expr.Run(p, env)
+		log.Printf("reusing %d artifacts of run %s", len(m.Artifacts), m.RunID)
+	}
+	// The index of the artifact keys is shared the same way, in the namespace of the run.
+	s, closeIndex, err := openArtifactsIndex()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeIndex()
+	index := store.WithNamespace(s, pipelineRun.ID())
+	dockerArtifactManager := artifacts.NewEngineArtifactsManager(pipelineRun, index, engine.Engine(containerEngine))
+	shellArtifactManager := artifacts.NewFilesystemArtifactsManager(pipelineRun, index, runner.WORKING_DIR)
+	artifactManagers := map[string]artifacts.ArtifactManager{
+		"docker": dockerArtifactManager,
+		"shell":  shellArtifactManager,
+	}
+
+	// The first signal stops the running jobs. Once the context is done the default handlers are
+	// restored, so a second signal kills dot right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	defer context.AfterFunc(ctx, func() {
+		stop()
+		log.Printf("interrupted, stopping jobs. Waiting up to %s for them to exit", gracePeriod)
+	})()
+
+	summary := pipeline.NewSummary(graph, skipped)
+	err = graph.Run(ctx, func(ctx context.Context, job models.Job) error {
+		name, err := runners.Resolve(job)
 		if err != nil {
-			log.Fatalf("condition evaluation failed for job %s: %v", v.Name, err)
+			return err
 		}
 
-		// Only append to stageMap if the condition evaluates to true
-		if output.(bool) {
-			stageMap[v.Stage] = append(stageMap[v.Stage], v)
+		// Every attempt runs in a new runner, and so a new container, with its own timeout.
+		timeout := jobTimeout(job, jobFile)
+		start := time.Now()
+		retryEvents := func(attempt int) runner.Events { return jobEvents(job, attempt) }
+		attempts, err := runner.Retry(ctx, job.Name, job.Retry, retryEvents, func(ctx context.Context, attempt int) error {
+			jobCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			stdout, stderr, events, done := jobLogs(job, attempt)
+			defer done()
+			r, err := runners.New(job, runner.Options{
+				Stdout:          stdout,
+				Stderr:          stderr,
+				ArtifactManager: artifactManagers[name],
+				Env:             environmentVariables,
+				Events:          events,
+			})
+			if err != nil {
+				return err
+			}
+			return r.Run(jobCtx)
+		})
+
+		result := pipeline.Result{Job: job.Name, Status: pipeline.StatusPassed, Attempts: attempts, Duration: time.Since(start)}
+		switch {
+		case err == nil && attempts > 1:
+			result.Status = pipeline.StatusPassedOnRetry
+		case errors.Is(err, runner.ErrCanceled):
+			result.Status = pipeline.StatusCanceled
+		case errors.Is(err, runner.ErrTimeout):
+			result.Status = pipeline.StatusTimedOut
+			result.Reason = fmt.Sprintf("timed out after %s", timeout)
+		case err != nil:
+			result.Status = pipeline.StatusFailed
+			result.Reason = err.Error()
 		}
+		if result.Status != pipeline.StatusCanceled && pipeline.AllowedFailure(job, err) {
+			result.Status = pipeline.StatusAllowedFailure
+		}
+		summary.Set(result)
+		return err
+	}, summary)
 
+	writeSummary(summary, pipelineRun.ID())
+	if ctx.Err() != nil {
+		os.Exit(exitCanceled)
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	dockerArtifactManager := artifacts.NewDockerArtifactsManager(".artifacts")
-
-	for _, v := range jobFile.Stages {
-		var eg errgroup.Group
-		for _, job := range stageMap[v] {
-			jobCtx, cancel := context.WithTimeout(ctx, time.Hour)
-			defer cancel()
+// attemptLabel returns the name that prefixes the logs of the job. Jobs that can be retried are labelled
+// with the attempt number.
+func attemptLabel(job models.Job, attempt int) string {
+	if job.Retry == nil || job.Retry.Attempts < 2 {
+		return job.Name
+	}
+	suffix := fmt.Sprintf(" #%d", attempt)
+	name := job.Name
+	if len(name)+len(suffix) > utils.MaxNameLength {
+		name = name[:utils.MaxNameLength-len(suffix)-3] + "..."
+	}
+	return name + suffix
+}
 
-			func(job models.Job) {
-				eg.Go(func() error {
-					return runner.NewDockerRunner(job.Name, dockerArtifactManager,
-						runner.DockerRunnerOptions{
-							ShowImagePull:     true,
-							Stdout:            utils.NewColorLogger(job.Name, os.Stdout, true),
-							Stderr:            utils.NewColorLogger(job.Name, os.Stderr, false),
-							MountDockerSocket: mountDockerSocket}).
-						WithImage(job.Image).
-						WithSrc(job.Src).
-						WithCmd(job.Script).
-						WithEntrypoint(job.Entrypoint).
-						WithEnv(append(job.Variables, environmentVariables...)).
-						WithCredentials(username, password).
-						CreatesArtifacts(job.Artifacts).Run(jobCtx)
-				})
-			}(job)
-		}
-		err := eg.Wait()
-		if err != nil {
-			log.Fatal(err)
-		}
+// jobTimeout returns the timeout of the job. Jobs without a timeout use the --timeout flag, then the
+// timeout of the job file and finally one hour.
+func jobTimeout(job models.Job, jobFile models.JobFile) time.Duration {
+	switch {
+	case job.Timeout > 0:
+		return job.Timeout
+	case defaultTimeout > 0:
+		return defaultTimeout
+	case jobFile.Timeout > 0:
+		return jobFile.Timeout
 	}
+	return time.Hour
 }
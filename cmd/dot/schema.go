@@ -0,0 +1,25 @@
+package dot
+
+import (
+	"log"
+	"os"
+
+	"github.com/opnlabs/dot/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON Schema of the job file",
+	Long: `Prints the JSON Schema of the job file. Editors can use it to provide
+autocompletion and validation for dot.yml.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := schema.Generate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := os.Stdout.Write(s); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
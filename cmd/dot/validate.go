@@ -0,0 +1,51 @@
+package dot
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/pipeline"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [job file]",
+	Short: "Validates a job file",
+	Long: `Validates a job file ( default dot.yml ) without running it.
+Every problem is reported with its position as file:line:column and the command
+exits with a non-zero status if any problem was found.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := jobFilePath
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		if err := parseVariables(); err != nil {
+			log.Fatal(err)
+		}
+
+		if _, err := loadJobFile(path, environmentVariables); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: valid\n", path)
+	},
+}
+
+func init() {
+	validateCmd.Flags().StringVarP(&jobFilePath, "job-file-path", "f", "dot.yml", "Path to the job file.")
+	validateCmd.Flags().StringArrayVarP(&envVars, "environment-variable", "e", make([]string, 0), "Environment variables used in conditions. KEY=VALUE")
+}
+
+// loadJobFile reads and validates the job file at path.
+func loadJobFile(path string, env []models.Variable) (models.JobFile, error) {
+	contents, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return models.JobFile{}, err
+	}
+	return pipeline.Parse(path, contents, env)
+}
@@ -0,0 +1,158 @@
+package artifacts
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backend stores the objects and manifests of a repository under slash separated names.
+type Backend interface {
+	// Read opens the file. It returns an error wrapping fs.ErrNotExist if the file does not exist.
+	Read(name string) (io.ReadCloser, error)
+	// Write creates or replaces the file with the contents of r. The file is not visible until it is complete.
+	Write(name string, r io.Reader) error
+	// Rename moves the file, replacing the file at the new name.
+	Rename(from, to string) error
+	// Stat returns an error wrapping fs.ErrNotExist if the file does not exist.
+	Stat(name string) (Object, error)
+	// List returns the files whose name starts with prefix.
+	List(prefix string) ([]Object, error)
+	Remove(name string) error
+	// RemoveStale deletes what is left of the writes interrupted more than olderThan ago, and returns
+	// the size freed.
+	RemoveStale(olderThan time.Duration) (int64, error)
+	// String describes where the files are stored.
+	String() string
+}
+
+// Object is a file in a Backend.
+type Object struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// DirBackend keeps the repository in a directory on the host.
+type DirBackend struct {
+	dir string
+}
+
+func NewDirBackend(dir string) *DirBackend {
+	return &DirBackend{dir: dir}
+}
+
+func (d *DirBackend) Read(name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+// Write writes to a temporary file that replaces the file once it is complete, so a run that is killed
+// does not leave a truncated file behind.
+func (d *DirBackend) Write(name string, r io.Reader) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d *DirBackend) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(d.path(to)), 0755); err != nil {
+		return err
+	}
+	return os.Rename(d.path(from), d.path(to))
+}
+
+func (d *DirBackend) Stat(name string) (Object, error) {
+	info, err := os.Stat(d.path(name))
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+// List skips the temporary files of writes in progress.
+func (d *DirBackend) List(prefix string) ([]Object, error) {
+	objects := make([]Object, 0)
+	root := d.path(prefix[:strings.LastIndex(prefix, "/")+1])
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return err
+		}
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Name: name, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (d *DirBackend) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+// RemoveStale deletes the temporary files that Write did not rename, anywhere in the directory.
+func (d *DirBackend) RemoveStale(olderThan time.Duration) (int64, error) {
+	var freed int64
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == d.dir {
+			return fs.SkipAll
+		}
+		if err != nil || entry.IsDir() || !strings.HasPrefix(entry.Name(), ".tmp-") {
+			return err
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// The write completed in the meantime.
+			return nil
+		}
+		if err != nil || time.Since(info.ModTime()) < olderThan {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		freed += info.Size()
+		return nil
+	})
+	return freed, err
+}
+
+func (d *DirBackend) String() string {
+	return d.dir
+}
+
+func (d *DirBackend) path(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
@@ -0,0 +1,134 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// File is a regular file inside an artifact.
+type File struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// Files returns the regular files in the tar archive of the artifact, sorted by name.
+func (r *Repository) Files(a Artifact) ([]File, error) {
+	f, err := r.Open(a.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	files := make([]File, 0)
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read artifact %s of job %s: %v", a.Path, a.Job, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		h := sha256.New()
+		size, err := io.Copy(h, tr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s in artifact %s of job %s: %v", header.Name, a.Path, a.Job, err)
+		}
+		files = append(files, File{Name: header.Name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// Change kinds.
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// Change is a file that differs between two runs.
+type Change struct {
+	Kind string
+	// Artifact is the name of the artifact that contains the file.
+	Artifact string
+	File     string
+	Old, New File
+}
+
+// Diff compares the files in the artifacts of two runs. Files are matched by the name of the artifact
+// and their name inside it. The changes are sorted by artifact and file.
+func (r *Repository) Diff(oldRun, newRun string) ([]Change, error) {
+	oldFiles, err := r.runFiles(oldRun)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := r.runFiles(newRun)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, 0)
+	for k, v := range oldFiles {
+		n, ok := newFiles[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: Removed, Artifact: k.artifact, File: k.file, Old: v})
+		case n.SHA256 != v.SHA256:
+			changes = append(changes, Change{Kind: Modified, Artifact: k.artifact, File: k.file, Old: v, New: n})
+		}
+	}
+	for k, v := range newFiles {
+		if _, ok := oldFiles[k]; !ok {
+			changes = append(changes, Change{Kind: Added, Artifact: k.artifact, File: k.file, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Artifact != changes[j].Artifact {
+			return changes[i].Artifact < changes[j].Artifact
+		}
+		return changes[i].File < changes[j].File
+	})
+	return changes, nil
+}
+
+type fileKey struct {
+	artifact string
+	file     string
+}
+
+// runFiles returns the files of every artifact of the run. Artifacts published before they had names
+// are named after their job.
+func (r *Repository) runFiles(runID string) (map[fileKey]File, error) {
+	m, err := r.Manifest(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[fileKey]File)
+	for _, a := range m.Artifacts {
+		name := a.Name
+		if len(name) == 0 {
+			name = a.Job
+		}
+		list, err := r.Files(a)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			files[fileKey{artifact: name, file: v.Name}] = v
+		}
+	}
+	return files, nil
+}
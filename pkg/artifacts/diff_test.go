@@ -0,0 +1,65 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tarFiles(t *testing.T, files map[string]string) *bytes.Buffer {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dist/", Mode: 0755}))
+	for name, contents := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(contents))}))
+		_, err := tw.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	return &b
+}
+
+func TestDiff(t *testing.T) {
+	repo, err := NewRepository(t.TempDir())
+	assert.NoError(t, err)
+
+	first, err := repo.NewRun("1")
+	assert.NoError(t, err)
+	a, err := first.Add("build", "", "/app/dist", tarFiles(t, map[string]string{"dist/dot": "v1", "dist/README": "readme", "dist/old": "old"}))
+	assert.NoError(t, err)
+	_, err = first.Add("docs", "site", "/app/public", tarFiles(t, map[string]string{"dist/index.html": "docs"}))
+	assert.NoError(t, err)
+
+	files, err := repo.Files(a)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dist/README", "dist/dot", "dist/old"}, []string{files[0].Name, files[1].Name, files[2].Name})
+	assert.Equal(t, int64(2), files[1].Size)
+
+	second, err := repo.NewRun("2")
+	assert.NoError(t, err)
+	_, err = second.Add("build", "", "/app/dist", tarFiles(t, map[string]string{"dist/dot": "v2", "dist/README": "readme", "dist/new": "new"}))
+	assert.NoError(t, err)
+	_, err = second.Add("docs", "site", "/app/public", tarFiles(t, map[string]string{"dist/index.html": "docs"}))
+	assert.NoError(t, err)
+
+	changes, err := repo.Diff("1", "2")
+	assert.NoError(t, err)
+	summary := make([]string, 0)
+	for _, v := range changes {
+		summary = append(summary, v.Kind+" "+v.Artifact+" "+v.File)
+	}
+	assert.Equal(t, []string{
+		"modified build dist/dot",
+		"added build dist/new",
+		"removed build dist/old",
+	}, summary)
+
+	changes, err = repo.Diff("2", "2")
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	_, err = repo.Diff("1", "3")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+}
@@ -2,121 +2,116 @@ package artifacts
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
+	"path"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/opnlabs/dot/pkg/engine"
+	"github.com/opnlabs/dot/pkg/models"
 	"github.com/opnlabs/dot/pkg/store"
+	"github.com/opnlabs/dot/pkg/utils"
 )
 
 type ArtifactManager interface {
-	PublishArtifact(jobID, path string) (key string, err error)
-	RetrieveArtifact(jobID string, keys []string) error
+	// PublishArtifact stores the files of the job that match the path of the artifact and returns the key
+	// of the artifact. The path and exclude patterns of a are absolute paths inside the job. It returns
+	// an error wrapping ErrNoMatch if no file matches.
+	PublishArtifact(job, jobID string, a models.Artifact) (key string, err error)
+	// RetrieveArtifact copies the artifacts published by the named jobs, under the given names or with
+	// the given keys. All the artifacts are copied if names is nil.
+	RetrieveArtifact(jobID string, names []string) error
 }
 
 type DockerArtifactsManager struct {
-	cli           *client.Client
-	artifactStore store.Store
-	artifactsDir  string
+	cli   *client.Client
+	run   *Run
+	index store.Store
 }
 
+// NewDockerArtifactsManager creates an artifact manager that records a new run in the repository
+// in artifactsDir.
 func NewDockerArtifactsManager(artifactsDir string) ArtifactManager {
-	// Clear previous artifacts and create a new directory
-	if _, err := os.Stat(artifactsDir); err == nil {
-		if err := os.RemoveAll(artifactsDir); err != nil {
-			log.Fatalf("could not remove %s directory: %v", artifactsDir, err)
-		}
+	repo, err := NewRepository(artifactsDir)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	if err := os.Mkdir(artifactsDir, 0755); err != nil {
-		log.Fatalf("could not create %s directory: %v", artifactsDir, err)
+	run, err := repo.NewRun(NewRunID())
+	if err != nil {
+		log.Fatal(err)
 	}
+	return NewEngineArtifactsManager(run, store.NewMemStore(store.Isolated()), engine.Docker)
+}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// NewEngineArtifactsManager creates an artifact manager that uses the given container engine. The keys
+// of the published artifacts are recorded in index, which can be shared with other managers of the run.
+func NewEngineArtifactsManager(run *Run, index store.Store, e engine.Engine) ArtifactManager {
+	cli, err := engine.NewClient(e)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	return &DockerArtifactsManager{
-		cli:           cli,
-		artifactStore: store.NewMemStore(),
-		artifactsDir:  artifactsDir,
+		cli:   cli,
+		run:   run,
+		index: index,
 	}
 }
 
-// PublishArtifact takes in a jobID and path inside the job and moves the artifact to the artifact store and returns a key
-// that references the artifact.
-func (d *DockerArtifactsManager) PublishArtifact(jobID, path string) (string, error) {
-	ctx := context.Background()
-	r, _, err := d.cli.CopyFromContainer(ctx, jobID, path)
-	if err != nil {
-		return "", fmt.Errorf("could not copy artifact %s from container %s: %v", path, jobID, err)
+// PublishArtifact takes in a jobID and an artifact inside the job and moves the matching files to the artifact store
+// and returns a key that references the artifact. The key is unique to the job, name, path and contents of the artifact.
+// Patterns are resolved by copying the directory before the first wildcard out of the stopped container.
+func (d *DockerArtifactsManager) PublishArtifact(job, jobID string, a models.Artifact) (string, error) {
+	base := utils.GlobBase(a.Path)
+	r, _, err := d.cli.CopyFromContainer(context.Background(), jobID, base)
+	if errdefs.IsNotFound(err) {
+		return "", fmt.Errorf("%w: %s", ErrNoMatch, a.Path)
 	}
-
-	f, err := os.CreateTemp(d.artifactsDir, "artifacts-*.tar")
 	if err != nil {
-		return "", fmt.Errorf("could not create artifacts tar file: %v", err)
+		return "", fmt.Errorf("could not copy artifact %s from container %s: %v", a.Path, jobID, err)
 	}
+	defer r.Close()
 
-	if _, err := io.Copy(f, r); err != nil {
+	files := selectFiles(r, path.Dir(base), a)
+	defer files.Close()
+	artifact, err := d.run.Add(job, a.Name, a.Path, files)
+	if errors.Is(err, ErrNoMatch) {
+		return "", err
+	}
+	if err != nil {
 		return "", fmt.Errorf("could not copy file contents from container %s to artifact tar: %v", jobID, err)
 	}
-
-	_, fname := filepath.Split(f.Name())
-	return fname, d.artifactStore.Set(strings.TrimSpace(fname), filepath.Dir(path))
+	return indexArtifact(d.index, artifact)
 }
 
-// RetrieveArtifact takes in a jobID, keys slice and moves the artifact to the original path inside the job.
-// If the keys is nil, all artifacts will be moved into the job.
-// The original path is the path from where the artifact was pushed in PublishArtifact.
-func (d *DockerArtifactsManager) RetrieveArtifact(jobID string, keys []string) error {
-	ctx := context.Background()
-
-	if len(keys) > 0 {
-		for _, v := range keys {
-			originalPath, err := d.artifactStore.Get(strings.TrimSpace(v))
-			if err != nil {
-				return fmt.Errorf("could not find original path for artifact %s: %v", v, err)
-			}
-			f, err := os.Open(filepath.Clean(v))
-			if err != nil {
-				return fmt.Errorf("could not open artifact %s: %v", v, err)
-			}
-			defer f.Close()
-
-			if err := d.cli.CopyToContainer(ctx, jobID, originalPath.(string), f, types.CopyToContainerOptions{}); err != nil {
-				return fmt.Errorf("could not copy artifact %s to container %s: %v", v, jobID, err)
-			}
-		}
+// RetrieveArtifact takes in a jobID, names slice and moves the artifacts to the original path inside the job.
+// Names can also be keys returned by PublishArtifact. If names is nil, all artifacts of the run will be moved
+// into the job. The original path is the path from where the artifact was pushed in PublishArtifact.
+func (d *DockerArtifactsManager) RetrieveArtifact(jobID string, names []string) error {
+	selected, err := selectArtifacts(d.run, d.index, names)
+	if err != nil {
+		return err
 	}
-
-	return filepath.Walk(d.artifactsDir, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
+	for _, v := range selected {
+		if err := d.copyToContainer(jobID, v); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		if !strings.Contains(path, ".tar") {
-			return nil
-		}
-
-		f, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("could not open %s artifact for copying to container %s: %v", path, jobID, err)
-		}
-		defer f.Close()
-
-		_, fname := filepath.Split(path)
-		originalPath, err := d.artifactStore.Get(strings.TrimSpace(fname))
-		if err != nil {
-			return fmt.Errorf("could not get %s from artifact store: %v", fname, err)
-		}
+func (d *DockerArtifactsManager) copyToContainer(jobID string, a Artifact) error {
+	f, err := d.run.Open(a)
+	if err != nil {
+		return fmt.Errorf("could not open artifact %s of job %s: %v", a.Path, a.Job, err)
+	}
+	defer f.Close()
 
-		return d.cli.CopyToContainer(context.Background(), jobID, originalPath.(string), f, types.CopyToContainerOptions{})
-	})
+	if err := d.cli.CopyToContainer(context.Background(), jobID, a.Dir(), f, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("could not copy artifact %s of job %s to container %s: %v", a.Path, a.Job, jobID, err)
+	}
+	return nil
 }
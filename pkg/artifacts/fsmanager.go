@@ -0,0 +1,122 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/store"
+	"github.com/opnlabs/dot/pkg/utils"
+)
+
+// FilesystemArtifactsManager manages artifacts for jobs that run directly on the host.
+// The jobID is the host directory the job runs in, which stands in for workingDir inside a container.
+// Artifacts are stored in the same format as DockerArtifactsManager, so jobs using either manager
+// can share artifacts.
+type FilesystemArtifactsManager struct {
+	run        *Run
+	index      store.Store
+	workingDir string
+}
+
+// NewFilesystemArtifactsManager creates an artifact manager that records its artifacts in run and their
+// keys in index. Pass the run and index of the DockerArtifactsManager so both managers share the artifacts.
+func NewFilesystemArtifactsManager(run *Run, index store.Store, workingDir string) ArtifactManager {
+	return &FilesystemArtifactsManager{
+		run:        run,
+		index:      index,
+		workingDir: workingDir,
+	}
+}
+
+// PublishArtifact takes in the job directory and an artifact inside workingDir, stores the matching files as a tar file
+// and returns a key that references the artifact.
+func (f *FilesystemArtifactsManager) PublishArtifact(job, jobID string, a models.Artifact) (string, error) {
+	base := utils.GlobBase(a.Path)
+	hostPath, err := f.hostPath(jobID, base)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(hostPath); errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNoMatch, a.Path)
+	} else if err != nil {
+		return "", fmt.Errorf("could not find artifact %s in %s: %v", a.Path, jobID, err)
+	}
+
+	t, err := os.CreateTemp("", "artifacts-*.tar")
+	if err != nil {
+		return "", fmt.Errorf("could not create artifacts tar file: %v", err)
+	}
+	defer os.Remove(t.Name())
+	if err := t.Close(); err != nil {
+		return "", fmt.Errorf("could not close artifacts tar file: %v", err)
+	}
+
+	if err := utils.CompressTarRelative(filepath.Dir(hostPath), filepath.Base(hostPath), t.Name()); err != nil {
+		return "", fmt.Errorf("could not copy artifact %s from %s to artifact tar: %v", a.Path, jobID, err)
+	}
+
+	r, err := os.Open(t.Name())
+	if err != nil {
+		return "", fmt.Errorf("could not open artifacts tar file: %v", err)
+	}
+	defer r.Close()
+
+	files := selectFiles(r, path.Dir(base), a)
+	defer files.Close()
+	artifact, err := f.run.Add(job, a.Name, a.Path, files)
+	if errors.Is(err, ErrNoMatch) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not store artifact %s from %s: %v", a.Path, jobID, err)
+	}
+	return indexArtifact(f.index, artifact)
+}
+
+// RetrieveArtifact takes in the job directory, names slice and extracts the artifacts to their original path
+// inside the job directory. Names can also be keys returned by PublishArtifact. If names is nil, all artifacts
+// of the run will be extracted.
+func (f *FilesystemArtifactsManager) RetrieveArtifact(jobID string, names []string) error {
+	selected, err := selectArtifacts(f.run, f.index, names)
+	if err != nil {
+		return err
+	}
+	for _, v := range selected {
+		target, err := f.hostPath(jobID, filepath.FromSlash(v.Dir()))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("could not create dir %s: %v", target, err)
+		}
+
+		r, err := f.run.Open(v)
+		if err != nil {
+			return fmt.Errorf("could not open artifact %s of job %s: %v", v.Path, v.Job, err)
+		}
+		err = utils.ExtractTar(r, target)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("could not extract artifact %s of job %s to %s: %v", v.Path, v.Job, target, err)
+		}
+	}
+	return nil
+}
+
+// hostPath maps a path inside workingDir to the same path inside the job directory.
+func (f *FilesystemArtifactsManager) hostPath(jobID, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.workingDir, path)
+	}
+	rel, err := filepath.Rel(f.workingDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("artifact path %s is outside %s", path, f.workingDir)
+	}
+	return filepath.Join(jobID, rel), nil
+}
@@ -0,0 +1,108 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/utils"
+)
+
+var ErrNoMatch = errors.New("artifacts: no files match the artifact path")
+
+// Dir returns the directory the tar archive of the artifact is extracted to.
+func (a Artifact) Dir() string {
+	return path.Dir(utils.GlobBase(a.Path))
+}
+
+// selectFiles returns the entries of the tar archive r that match the path of the artifact and none of
+// its exclude patterns. The names in r are relative to dir. Entries match when a pattern matches them
+// or one of their parent directories, and the directories that contain matched entries are kept.
+// Reading the returned archive fails with ErrNoMatch if no entry matches. The archive must be closed,
+// which stops the goroutine that writes it if it was not read to the end.
+func selectFiles(r io.Reader, dir string, a models.Artifact) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		pending := make([]*tar.Header, 0)
+		matched := false
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pw.CloseWithError(fmt.Errorf("could not read tar header: %v", err))
+				return
+			}
+
+			name := path.Join(dir, header.Name)
+			if !includes(a, name) {
+				if header.Typeflag == tar.TypeDir {
+					pending = append(pending, header)
+				}
+				continue
+			}
+			matched = true
+
+			// Write the skipped directories that contain the entry, so it is extracted with their modes.
+			remaining := pending[:0]
+			for _, v := range pending {
+				if !strings.HasPrefix(name, path.Join(dir, v.Name)+"/") {
+					remaining = append(remaining, v)
+					continue
+				}
+				if err := tw.WriteHeader(v); err != nil {
+					pw.CloseWithError(fmt.Errorf("could not write tar header %s: %v", v.Name, err))
+					return
+				}
+			}
+			pending = remaining
+
+			if err := tw.WriteHeader(header); err != nil {
+				pw.CloseWithError(fmt.Errorf("could not write tar header %s: %v", header.Name, err))
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(fmt.Errorf("could not copy tar contents for file %s: %v", header.Name, err))
+				return
+			}
+		}
+		if !matched {
+			pw.CloseWithError(fmt.Errorf("%w: %s", ErrNoMatch, a.Path))
+			return
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
+func includes(a models.Artifact, name string) bool {
+	if !matchesPath(a.Path, name) {
+		return false
+	}
+	for _, v := range a.Exclude {
+		if matchesPath(v, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPath returns true if the pattern matches the name or one of its parent directories.
+func matchesPath(pattern, name string) bool {
+	for {
+		if utils.MatchGlob(pattern, name) {
+			return true
+		}
+		parent := path.Dir(name)
+		if parent == name {
+			return false
+		}
+		name = parent
+	}
+}
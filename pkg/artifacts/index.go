@@ -0,0 +1,84 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/store"
+)
+
+// indexTTL is how long the index keeps the key of an artifact. Keys are only needed while the run
+// that published them is going on.
+const indexTTL = 24 * time.Hour
+
+// artifactKey identifies the artifact by its job, name, path and contents. Artifacts with the same
+// contents published by different jobs or from different paths get different keys.
+func artifactKey(a Artifact) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{a.Job, a.Name, a.Path, a.SHA256}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// indexArtifact records the artifact and returns its key, which PublishArtifact returns. Publishing
+// the same artifact again replaces the entry.
+func indexArtifact(index store.Store, a Artifact) (string, error) {
+	key := artifactKey(a)
+	err := index.Set(key, a)
+	if errors.Is(err, store.ErrKeyExists) {
+		err = index.Update(key, a)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not index artifact %s of job %s: %v", a.Path, a.Job, err)
+	}
+	return key, index.Expire(key, indexTTL)
+}
+
+// selectArtifacts returns the artifacts of the run published by the named jobs, under the given names
+// or with the keys returned by PublishArtifact. All the artifacts are returned if names is nil.
+func selectArtifacts(run *Run, index store.Store, names []string) ([]Artifact, error) {
+	selected := run.Select(names)
+	for _, v := range names {
+		value, err := index.Get(v)
+		if errors.Is(err, store.ErrKeyDoesntExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not look up artifact %s: %v", v, err)
+		}
+		a, err := decodeArtifact(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not read artifact %s from the index: %v", v, err)
+		}
+		if !containsArtifact(selected, a) {
+			selected = append(selected, a)
+		}
+	}
+	return selected, nil
+}
+
+// decodeArtifact converts the value of the index. Stores that keep their values as JSON return them
+// as a map.
+func decodeArtifact(value interface{}) (Artifact, error) {
+	if a, ok := value.(Artifact); ok {
+		return a, nil
+	}
+	var a Artifact
+	b, err := json.Marshal(value)
+	if err != nil {
+		return a, err
+	}
+	return a, json.Unmarshal(b, &a)
+}
+
+func containsArtifact(artifacts []Artifact, a Artifact) bool {
+	for _, v := range artifacts {
+		if v.Job == a.Job && v.Path == a.Path && v.SHA256 == a.SHA256 {
+			return true
+		}
+	}
+	return false
+}
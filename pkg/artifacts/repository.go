@@ -0,0 +1,397 @@
+package artifacts
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatestRun refers to the most recent run in the repository.
+const LatestRun = "latest"
+
+// staleTmpAge is the age after which GC considers a temporary file an interrupted upload.
+const staleTmpAge = time.Hour
+
+var (
+	ErrRunNotFound      = errors.New("artifacts: run not found")
+	ErrArtifactNotFound = errors.New("artifacts: artifact not found")
+
+	runIDRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// Artifact describes a path published by a job.
+type Artifact struct {
+	Job string `json:"job"`
+	// Name is used in the dependencies of other jobs. It defaults to the name of the job.
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	// RunID is the run that published the artifact, which differs from the run of the manifest
+	// when the artifact was reused from an earlier run.
+	RunID string `json:"run_id"`
+}
+
+// Manifest lists the artifacts available to the jobs of a run.
+type Manifest struct {
+	RunID     string     `json:"run_id"`
+	Created   time.Time  `json:"created"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Select returns the artifacts published by the named jobs or under the given names. All the artifacts
+// are returned if names is nil.
+func (m Manifest) Select(names []string) []Artifact {
+	if names == nil {
+		return append([]Artifact(nil), m.Artifacts...)
+	}
+
+	selected := make([]Artifact, 0)
+	for _, v := range m.Artifacts {
+		for _, name := range names {
+			if v.Job == name || v.Name == name {
+				selected = append(selected, v)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// Repository keeps artifacts across runs. The tar archives are stored once per content in objects/,
+// named by their sha256, and every run has a manifest in runs/. The files are kept by a Backend, which
+// can be a directory on the host or an S3 bucket.
+type Repository struct {
+	backend Backend
+}
+
+// NewRepository creates the directory of the repository if it does not exist.
+func NewRepository(dir string) (*Repository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create %s directory: %v", dir, err)
+	}
+	return NewBackendRepository(NewDirBackend(dir)), nil
+}
+
+// NewBackendRepository creates a repository that keeps its files in backend.
+func NewBackendRepository(backend Backend) *Repository {
+	return &Repository{backend: backend}
+}
+
+// NewRunID returns an id that sorts in the order the runs started.
+func NewRunID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Runs returns the manifests of the runs, the most recent first.
+func (r *Repository) Runs() ([]Manifest, error) {
+	objects, err := r.backend.List("runs/")
+	if err != nil {
+		return nil, fmt.Errorf("could not list the runs in %s: %v", r.backend, err)
+	}
+
+	runs := make([]Manifest, 0, len(objects))
+	for _, v := range objects {
+		runID, ok := strings.CutSuffix(strings.TrimPrefix(v.Name, "runs/"), ".json")
+		if !ok || strings.Contains(runID, "/") {
+			continue
+		}
+		m, err := r.Manifest(runID)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, m)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		if runs[i].Created.Equal(runs[j].Created) {
+			return runs[i].RunID > runs[j].RunID
+		}
+		return runs[i].Created.After(runs[j].Created)
+	})
+	return runs, nil
+}
+
+// Manifest returns the manifest of the run. The run id can be LatestRun.
+func (r *Repository) Manifest(runID string) (Manifest, error) {
+	if runID == LatestRun {
+		runs, err := r.Runs()
+		if err != nil {
+			return Manifest{}, err
+		}
+		if len(runs) == 0 {
+			return Manifest{}, fmt.Errorf("%w: there are no runs in %s", ErrRunNotFound, r.backend)
+		}
+		return runs[0], nil
+	}
+	if !runIDRegexp.MatchString(runID) {
+		return Manifest{}, fmt.Errorf("%w: invalid run id %s", ErrRunNotFound, runID)
+	}
+
+	f, err := r.backend.Read(manifestPath(runID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Manifest{}, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("could not read manifest of run %s: %v", runID, err)
+	}
+	return m, nil
+}
+
+// NewRun starts a run with an empty manifest.
+func (r *Repository) NewRun(runID string) (*Run, error) {
+	if !runIDRegexp.MatchString(runID) {
+		return nil, fmt.Errorf("invalid run id %s", runID)
+	}
+	run := &Run{
+		repo:     r,
+		manifest: Manifest{RunID: runID, Created: time.Now().UTC(), Artifacts: make([]Artifact, 0)},
+	}
+	return run, r.writeManifest(run.manifest)
+}
+
+// Open returns the tar archive with the given sha256.
+func (r *Repository) Open(sha string) (io.ReadCloser, error) {
+	f, err := r.backend.Read(objectPath(sha))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: no contents with sha256 %s", ErrArtifactNotFound, sha)
+	}
+	return f, err
+}
+
+// GCResult describes what was removed by GC.
+type GCResult struct {
+	Runs  []string
+	Freed int64
+}
+
+// GC removes the runs after the keep most recent ones and the runs created before olderThan ago.
+// A zero keep or olderThan disables that rule. The contents that are no longer referenced by a run
+// are deleted, and so are the temporary files and uploads that were interrupted more than staleTmpAge
+// ago. GC should not run while a pipeline publishes artifacts to the repository.
+func (r *Repository) GC(keep int, olderThan time.Duration) (GCResult, error) {
+	var result GCResult
+	runs, err := r.Runs()
+	if err != nil {
+		return result, err
+	}
+
+	referenced := make(map[string]bool)
+	for i, v := range runs {
+		if (keep > 0 && i >= keep) || (olderThan > 0 && time.Since(v.Created) > olderThan) {
+			if err := r.backend.Remove(manifestPath(v.RunID)); err != nil {
+				return result, err
+			}
+			result.Runs = append(result.Runs, v.RunID)
+			continue
+		}
+		for _, a := range v.Artifacts {
+			referenced[a.SHA256] = true
+		}
+	}
+
+	objects, err := r.backend.List("objects/")
+	if err != nil {
+		return result, err
+	}
+	for _, v := range objects {
+		sha, ok := strings.CutSuffix(path.Base(v.Name), ".tar")
+		if !ok || referenced[sha] {
+			continue
+		}
+		if err := r.backend.Remove(v.Name); err != nil {
+			return result, err
+		}
+		result.Freed += v.Size
+	}
+
+	tmp, err := r.backend.List("tmp/")
+	if err != nil {
+		return result, err
+	}
+	for _, v := range tmp {
+		if time.Since(v.Modified) < staleTmpAge {
+			continue
+		}
+		if err := r.backend.Remove(v.Name); err != nil {
+			return result, err
+		}
+		result.Freed += v.Size
+	}
+
+	freed, err := r.backend.RemoveStale(staleTmpAge)
+	result.Freed += freed
+	return result, err
+}
+
+// add stores the contents and returns their sha256 and size. The contents are streamed to a temporary
+// file that is renamed after their sha256 once it is known.
+func (r *Repository) add(contents io.Reader) (string, int64, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, err
+	}
+	tmp := "tmp/" + hex.EncodeToString(b) + ".tar"
+	h := sha256.New()
+	counter := &countingWriter{}
+	if err := r.backend.Write(tmp, io.TeeReader(contents, io.MultiWriter(h, counter))); err != nil {
+		r.backend.Remove(tmp)
+		return "", 0, err
+	}
+
+	sha := hex.EncodeToString(h.Sum(nil))
+	if _, err := r.backend.Stat(objectPath(sha)); err == nil {
+		return sha, counter.n, r.backend.Remove(tmp)
+	}
+	if err := r.backend.Rename(tmp, objectPath(sha)); err != nil {
+		r.backend.Remove(tmp)
+		return "", 0, err
+	}
+	return sha, counter.n, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return len(b), nil
+}
+
+func objectPath(sha string) string {
+	if len(sha) < 2 {
+		return "objects/" + sha + ".tar"
+	}
+	return "objects/" + sha[:2] + "/" + sha + ".tar"
+}
+
+func manifestPath(runID string) string {
+	return "runs/" + runID + ".json"
+}
+
+// writeManifest replaces the manifest, so a run that is killed leaves a readable manifest.
+func (r *Repository) writeManifest(m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.backend.Write(manifestPath(m.RunID), bytes.NewReader(b))
+}
+
+// Run records the artifacts of a pipeline run in its manifest. It is safe for concurrent use by the
+// jobs of the run.
+type Run struct {
+	repo     *Repository
+	lock     sync.Mutex
+	manifest Manifest
+}
+
+func (r *Run) ID() string {
+	return r.manifest.RunID
+}
+
+// Import adds the artifacts of an earlier run, so jobs of this run can use them without running the
+// jobs that published them. Artifacts published again in this run replace the imported ones.
+// LatestRun refers to the most recent run other than this one.
+func (r *Run) Import(runID string) (Manifest, error) {
+	m, err := r.earlierRun(runID)
+	if err != nil {
+		return m, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, v := range m.Artifacts {
+		r.manifest.Artifacts = replaceArtifact(r.manifest.Artifacts, v)
+	}
+	return m, r.repo.writeManifest(r.manifest)
+}
+
+func (r *Run) earlierRun(runID string) (Manifest, error) {
+	if runID != LatestRun {
+		return r.repo.Manifest(runID)
+	}
+	runs, err := r.repo.Runs()
+	if err != nil {
+		return Manifest{}, err
+	}
+	for _, v := range runs {
+		if v.RunID != r.ID() {
+			return v, nil
+		}
+	}
+	return Manifest{}, fmt.Errorf("%w: there are no earlier runs in %s", ErrRunNotFound, r.repo.backend)
+}
+
+// Add stores the tar archive of the path published by the job under name, or the name of the job
+// if name is empty.
+func (r *Run) Add(job, name, path string, contents io.Reader) (Artifact, error) {
+	sha, size, err := r.repo.add(contents)
+	if err != nil {
+		return Artifact{}, err
+	}
+	if len(name) == 0 {
+		name = job
+	}
+	a := Artifact{Job: job, Name: name, Path: path, SHA256: sha, Size: size, Created: time.Now().UTC(), RunID: r.ID()}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.manifest.Artifacts = replaceArtifact(r.manifest.Artifacts, a)
+	return a, r.repo.writeManifest(r.manifest)
+}
+
+// Artifacts returns the artifacts of the run in the order they were added.
+func (r *Run) Artifacts() []Artifact {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Artifact(nil), r.manifest.Artifacts...)
+}
+
+// Select returns the artifacts of the run published by the named jobs or under the given names.
+// All the artifacts are returned if names is nil.
+func (r *Run) Select(names []string) []Artifact {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.manifest.Select(names)
+}
+
+// Open returns the tar archive of the artifact.
+func (r *Run) Open(a Artifact) (io.ReadCloser, error) {
+	return r.repo.Open(a.SHA256)
+}
+
+// replaceArtifact adds the artifact, replacing the one published by the same job at the same path.
+func replaceArtifact(artifacts []Artifact, a Artifact) []Artifact {
+	for i, v := range artifacts {
+		if v.Job == a.Job && v.Path == a.Path {
+			artifacts[i] = a
+			return artifacts
+		}
+	}
+	return append(artifacts, a)
+}
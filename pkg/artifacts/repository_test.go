@@ -0,0 +1,165 @@
+package artifacts
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	repo, err := NewRepository(t.TempDir())
+	assert.NoError(t, err)
+	testRepository(t, repo)
+}
+
+func testRepository(t *testing.T, repo *Repository) {
+	first, err := repo.NewRun("1")
+	assert.NoError(t, err)
+	build, err := first.Add("build", "", "/app/dist", strings.NewReader("dist"))
+	assert.NoError(t, err)
+	_, err = first.Add("docs", "", "/app/docs", strings.NewReader("docs"))
+	assert.NoError(t, err)
+	same, err := first.Add("copy", "dist", "/app/dist", strings.NewReader("dist"))
+	assert.NoError(t, err)
+	assert.Equal(t, build.SHA256, same.SHA256, "the same contents should have the same sha256")
+	assert.Equal(t, int64(4), build.Size)
+
+	r, err := first.Open(build)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "dist", string(b))
+
+	second, err := repo.NewRun("2")
+	assert.NoError(t, err)
+	m, err := second.Import(LatestRun)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", m.RunID)
+	_, err = second.Add("docs", "", "/app/docs", strings.NewReader("new docs"))
+	assert.NoError(t, err)
+
+	list := second.Artifacts()
+	assert.Len(t, list, 3)
+	assert.Equal(t, "1", list[0].RunID)
+	assert.Equal(t, "2", list[1].RunID, "the published artifact should replace the imported one")
+
+	assert.Len(t, second.Select([]string{"dist"}), 1)
+	assert.Len(t, second.Select([]string{"build", "docs"}), 2)
+	assert.Empty(t, second.Select([]string{}))
+	assert.Empty(t, second.Select([]string{"unknown"}))
+	_, err = repo.Manifest("3")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+	_, err = repo.Manifest("../runs")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+
+	result, err := repo.GC(1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, result.Runs)
+	assert.Equal(t, int64(len("docs")), result.Freed, "only the docs of the first run are unused")
+	_, err = repo.Open(build.SHA256)
+	assert.NoError(t, err)
+
+	result, err = repo.GC(0, time.Nanosecond)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2"}, result.Runs)
+	_, err = repo.Open(build.SHA256)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+}
+
+func TestGCTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	backend := NewDirBackend(dir)
+	assert.NoError(t, backend.Write("tmp/interrupted.tar", strings.NewReader("partial")))
+	assert.NoError(t, backend.Write("tmp/uploading.tar", strings.NewReader("upload")))
+	old := time.Now().Add(-2 * staleTmpAge)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "tmp", "interrupted.tar"), old, old))
+
+	result, err := repo.GC(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("partial")), result.Freed)
+	_, err = backend.Stat("tmp/interrupted.tar")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = backend.Stat("tmp/uploading.tar")
+	assert.NoError(t, err, "recent temporary files may belong to an upload in progress")
+
+	// A write that was killed before the rename leaves its temporary file next to the destination.
+	for _, v := range []string{"objects/ab/.tmp-123", "objects/ab/.tmp-456"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(v)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, v), []byte("half"), 0644))
+	}
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "objects", "ab", ".tmp-123"), old, old))
+
+	result, err = repo.GC(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("half")), result.Freed)
+	_, err = os.Stat(filepath.Join(dir, "objects", "ab", ".tmp-123"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, "objects", "ab", ".tmp-456"))
+	assert.NoError(t, err)
+}
+
+func TestSelectArtifacts(t *testing.T) {
+	repo, err := NewRepository(t.TempDir())
+	assert.NoError(t, err)
+	run, err := repo.NewRun("1")
+	assert.NoError(t, err)
+	// The file store returns its values as JSON, unlike the memory store.
+	s, err := store.NewFileStore(filepath.Join(t.TempDir(), "index.json"))
+	assert.NoError(t, err)
+	defer s.Close()
+	index := store.WithNamespace(s, run.ID())
+
+	build, err := run.Add("build", "", "/app/dist", strings.NewReader("dist"))
+	assert.NoError(t, err)
+	buildKey, err := indexArtifact(index, build)
+	assert.NoError(t, err)
+	// Same contents, another job and path.
+	copied, err := run.Add("copy", "", "/app/out", strings.NewReader("dist"))
+	assert.NoError(t, err)
+	copiedKey, err := indexArtifact(index, copied)
+	assert.NoError(t, err)
+	assert.NotEqual(t, buildKey, copiedKey, "artifacts with the same contents should have different keys")
+	again, err := indexArtifact(index, copied)
+	assert.NoError(t, err, "publishing the same artifact again should update the index")
+	assert.Equal(t, copiedKey, again)
+
+	selected, err := selectArtifacts(run, index, []string{buildKey})
+	assert.NoError(t, err)
+	assert.Equal(t, []Artifact{build}, selected)
+	selected, err = selectArtifacts(run, index, []string{"copy", copiedKey})
+	assert.NoError(t, err)
+	assert.Equal(t, []Artifact{copied}, selected, "an artifact selected by name and key should be returned once")
+	selected, err = selectArtifacts(run, index, nil)
+	assert.NoError(t, err)
+	assert.Len(t, selected, 2)
+	selected, err = selectArtifacts(run, index, []string{"unknown"})
+	assert.NoError(t, err)
+	assert.Empty(t, selected)
+}
+
+func TestSelectFilesClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	src := tarFiles(t, map[string]string{"dist/app": strings.Repeat("a", 1<<20)})
+	files := selectFiles(src, "/app", models.Artifact{Path: "/app/dist"})
+	_, err := io.ReadFull(files, make([]byte, 512))
+	assert.NoError(t, err)
+
+	// A consumer that fails before the end closes the archive, which stops the writing goroutine.
+	assert.NoError(t, files.Close())
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
@@ -0,0 +1,172 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is the size of the parts of streamed uploads. Every upload buffers one part in memory.
+const s3PartSize = 16 * 1024 * 1024
+
+// S3Config selects the bucket of an S3Backend.
+type S3Config struct {
+	// Endpoint is the host and port of the S3 API, like s3.amazonaws.com or localhost:9000 for MinIO.
+	Endpoint string `yaml:"endpoint"`
+	Bucket   string `yaml:"bucket"`
+	// Prefix is prepended to the names of the files, so several repositories can share a bucket.
+	Prefix string `yaml:"prefix"`
+	Region string `yaml:"region"`
+	// Insecure uses http instead of https.
+	Insecure bool `yaml:"insecure"`
+	// AccessKeyID and SecretAccessKey are read from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+	// or MINIO_ROOT_USER and MINIO_ROOT_PASSWORD, environment variables and the AWS credentials file
+	// when they are empty.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// S3Backend keeps the repository in a bucket of an S3 compatible object store. Files are streamed in
+// both directions, without being copied to the disk first.
+type S3Backend struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Backend connects to the endpoint and checks that the bucket exists.
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	creds := credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	if len(cfg.AccessKeyID) == 0 {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create S3 client for %s: %v", cfg.Endpoint, err)
+	}
+
+	ok, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not find bucket %s on %s: %v", cfg.Bucket, cfg.Endpoint, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %s does not exist on %s", cfg.Bucket, cfg.Endpoint)
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	return &S3Backend{client: client, bucket: cfg.Bucket, prefix: prefix}, nil
+}
+
+func (s *S3Backend) Read(name string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	if _, err := s.Stat(name); err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucket, s.prefix+name, minio.GetObjectOptions{})
+}
+
+// Write uploads r in parts. The object only becomes visible once the upload completes.
+func (s *S3Backend) Write(name string, r io.Reader) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.prefix+name, r, -1, minio.PutObjectOptions{
+		ContentType: contentType(name),
+		PartSize:    s3PartSize,
+	})
+	return err
+}
+
+// Rename copies the object on the server, in parts when it is larger than a single copy allows,
+// and removes the original.
+func (s *S3Backend) Rename(from, to string) error {
+	ctx := context.Background()
+	_, err := s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.prefix + to},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.prefix + from},
+	)
+	if err != nil {
+		return err
+	}
+	return s.Remove(from)
+}
+
+func (s *S3Backend) Stat(name string) (Object, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.prefix+name, minio.StatObjectOptions{})
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NotFound" {
+		return Object{}, fmt.Errorf("%w: %s", fs.ErrNotExist, s.url(name))
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Name: name, Size: info.Size, Modified: info.LastModified}, nil
+}
+
+func (s *S3Backend) List(prefix string) ([]Object, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	objects := make([]Object, 0)
+	for v := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + prefix, Recursive: true}) {
+		if v.Err != nil {
+			return nil, v.Err
+		}
+		objects = append(objects, Object{Name: strings.TrimPrefix(v.Key, s.prefix), Size: v.Size, Modified: v.LastModified})
+	}
+	return objects, nil
+}
+
+func (s *S3Backend) Remove(name string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+// RemoveStale aborts the multipart uploads started more than olderThan ago. Their parts are kept by
+// the bucket until the upload is completed or aborted.
+func (s *S3Backend) RemoveStale(olderThan time.Duration) (int64, error) {
+	ctx := context.Background()
+	var freed int64
+	for v := range s.client.ListIncompleteUploads(ctx, s.bucket, s.prefix, true) {
+		if v.Err != nil {
+			return freed, v.Err
+		}
+		if time.Since(v.Initiated) < olderThan {
+			continue
+		}
+		if err := s.client.RemoveIncompleteUpload(ctx, s.bucket, v.Key); err != nil {
+			return freed, err
+		}
+		freed += v.Size
+	}
+	return freed, nil
+}
+
+func (s *S3Backend) String() string {
+	return s.url("")
+}
+
+func (s *S3Backend) url(name string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + name
+}
+
+func contentType(name string) string {
+	if strings.HasSuffix(name, ".json") {
+		return "application/json"
+	}
+	return "application/x-tar"
+}
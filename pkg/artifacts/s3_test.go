@@ -0,0 +1,123 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/opnlabs/dot/pkg/engine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	minioImage    = "docker.io/minio/minio:latest"
+	minioUser     = "dot-test"
+	minioPassword = "dot-test-password"
+)
+
+// startMinIO returns the config of an empty bucket. It uses the MinIO server at DOT_TEST_S3_ENDPOINT,
+// with the credentials in MINIO_ROOT_USER and MINIO_ROOT_PASSWORD, or starts one in a container.
+func startMinIO(t *testing.T) S3Config {
+	cfg := S3Config{
+		Endpoint:        os.Getenv("DOT_TEST_S3_ENDPOINT"),
+		Bucket:          "dot-test-" + NewRunID(),
+		Prefix:          "ci/",
+		Insecure:        true,
+		AccessKeyID:     minioUser,
+		SecretAccessKey: minioPassword,
+	}
+	if len(cfg.Endpoint) > 0 {
+		cfg.AccessKeyID, cfg.SecretAccessKey = os.Getenv("MINIO_ROOT_USER"), os.Getenv("MINIO_ROOT_PASSWORD")
+	} else {
+		cfg.Endpoint = startMinIOContainer(t)
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{Creds: credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")})
+	require.NoError(t, err)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err = client.MakeBucket(context.Background(), cfg.Bucket, minio.MakeBucketOptions{})
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	return cfg
+}
+
+func startMinIOContainer(t *testing.T) string {
+	ctx := context.Background()
+	cli, err := engine.NewClient(engine.Docker)
+	require.NoError(t, err)
+
+	r, err := cli.ImagePull(ctx, minioImage, types.ImagePullOptions{})
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, r)
+	r.Close()
+	require.NoError(t, err)
+
+	port := nat.Port("9000/tcp")
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:        minioImage,
+		Cmd:          []string{"server", "/data"},
+		Env:          []string{"MINIO_ROOT_USER=" + minioUser, "MINIO_ROOT_PASSWORD=" + minioPassword},
+		ExposedPorts: nat.PortSet{port: struct{}{}},
+	}, &container.HostConfig{
+		PortBindings: nat.PortMap{port: []nat.PortBinding{{HostIP: "127.0.0.1"}}},
+	}, nil, nil, "")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
+	})
+	require.NoError(t, cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}))
+
+	info, err := cli.ContainerInspect(ctx, resp.ID)
+	require.NoError(t, err)
+	bindings := info.NetworkSettings.Ports[port]
+	require.NotEmpty(t, bindings)
+	return "127.0.0.1:" + bindings[0].HostPort
+}
+
+func TestS3Repository(t *testing.T) {
+	cfg := startMinIO(t)
+	backend, err := NewS3Backend(cfg)
+	require.NoError(t, err)
+	repo := NewBackendRepository(backend)
+	testRepository(t, repo)
+
+	// Larger contents are uploaded in several parts.
+	contents := make([]byte, s3PartSize+1024)
+	_, err = rand.Read(contents)
+	require.NoError(t, err)
+	run, err := repo.NewRun("3")
+	require.NoError(t, err)
+	a, err := run.Add("build", "", "/app/dist", bytes.NewReader(contents))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), a.Size)
+
+	r, err := run.Open(a)
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, contents, b)
+
+	objects, err := backend.List("tmp/")
+	assert.NoError(t, err)
+	assert.Empty(t, objects, "temporary uploads should be removed")
+
+	cfg.Bucket += "-missing"
+	_, err = NewS3Backend(cfg)
+	assert.ErrorContains(t, err, "does not exist")
+}
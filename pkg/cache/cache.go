@@ -0,0 +1,274 @@
+// Package cache keeps files produced by jobs, such as downloaded dependencies, between runs.
+//
+// Entries are stored under a key, usually rendered from a template that hashes lock files, and
+// hold one tar archive for every cached path. The archives are kept by a Backend, which can be a
+// directory on the host or a named volume of the container engine.
+package cache
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const indexName = "index.json"
+
+var ErrNotFound = errors.New("cache: no entry matches the key")
+
+// Backend stores the files of the cache under slash separated names.
+type Backend interface {
+	// Read opens the file. It returns an error wrapping fs.ErrNotExist if the file does not exist.
+	Read(name string) (io.ReadCloser, error)
+	// Write creates or replaces the file with the contents of r.
+	Write(name string, r io.Reader) error
+	// Remove deletes the directory or file.
+	Remove(name string) error
+	// Clear deletes everything in the cache.
+	Clear() error
+}
+
+// Entry describes the contents stored under a key.
+type Entry struct {
+	Key     string    `json:"key"`
+	Paths   []string  `json:"paths"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	// ID names the directory of the archives. Every save uses a new one, so saving a key does not
+	// replace the archives an earlier entry is being restored from.
+	ID string `json:"id,omitempty"`
+}
+
+// id is the directory that holds the archives of the entry. Entries written before IDs were added
+// use the hash of their key.
+func (e Entry) id() string {
+	if len(e.ID) > 0 {
+		return e.ID
+	}
+	sum := sha256.Sum256([]byte(e.Key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// newID returns a random directory name for the archives of an entry.
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (e Entry) archive(path string) string {
+	for i, v := range e.Paths {
+		if v == path {
+			return fmt.Sprintf("%s/%d.tar", e.id(), i)
+		}
+	}
+	return ""
+}
+
+// Cache stores entries in a backend. It is safe for concurrent use by the jobs of a run.
+type Cache struct {
+	lock    sync.Mutex
+	backend Backend
+}
+
+func New(backend Backend) *Cache {
+	return &Cache{backend: backend}
+}
+
+// Find returns the entry stored under key. Otherwise, the most recent entry whose key starts with one of
+// the fallback keys is returned, trying the fallback keys in order.
+func (c *Cache) Find(key string, fallbackKeys []string) (Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	for _, v := range entries {
+		if v.Key == key {
+			return v, nil
+		}
+	}
+	// List returns the most recent entries first.
+	for _, prefix := range fallbackKeys {
+		for _, v := range entries {
+			if strings.HasPrefix(v.Key, prefix) {
+				return v, nil
+			}
+		}
+	}
+	return Entry{}, fmt.Errorf("%w %s", ErrNotFound, key)
+}
+
+// Open returns the tar archive of the path stored in the entry.
+func (c *Cache) Open(entry Entry, path string) (io.ReadCloser, error) {
+	name := entry.archive(path)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("cache: path %s is not stored under %s", path, entry.Key)
+	}
+	return c.backend.Read(name)
+}
+
+// Save stores the tar archives of the paths under key, replacing the previous entry with the same key.
+// The archives are read from open, which is called once for every path. They are written to a new
+// directory, and the archives of the previous entry are only removed once the index points to the new
+// one, so concurrent saves and restores of the key do not see half written archives.
+func (c *Cache) Save(key string, paths []string, open func(path string) (io.ReadCloser, error)) (Entry, error) {
+	id, err := newID()
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{Key: key, Paths: paths, Created: time.Now().UTC(), ID: id}
+	for _, path := range paths {
+		n, err := c.write(entry, path, open)
+		if err != nil {
+			c.backend.Remove(entry.id())
+			return entry, err
+		}
+		entry.Size += n
+	}
+
+	previous, err := c.replace(entry)
+	if err != nil {
+		c.backend.Remove(entry.id())
+		return entry, err
+	}
+
+	for _, v := range previous {
+		if err := c.backend.Remove(v.id()); err != nil {
+			return entry, fmt.Errorf("could not remove the previous cache of %s: %v", key, err)
+		}
+	}
+	return entry, nil
+}
+
+// replace points the index to the entry instead of the previous entries with its key, and returns them.
+func (c *Cache) replace(entry Entry) ([]Entry, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	previous := make([]Entry, 0)
+	for _, v := range entries {
+		if v.Key == entry.Key {
+			previous = append(previous, v)
+		}
+	}
+	return previous, c.writeIndex(append(removeKey(entries, entry.Key), entry))
+}
+
+// write stores the archive of the path in the directory of the entry and returns its size.
+func (c *Cache) write(entry Entry, path string, open func(path string) (io.ReadCloser, error)) (int64, error) {
+	r, err := open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	counter := &countingReader{r: r}
+	if err := c.backend.Write(entry.archive(path), counter); err != nil {
+		return 0, fmt.Errorf("could not write cache of %s: %v", path, err)
+	}
+	return counter.n, nil
+}
+
+// List returns the entries in the cache, the most recent first.
+func (c *Cache) List() ([]Entry, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created.After(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// Remove deletes the entries whose key starts with prefix and returns them.
+func (c *Cache) Remove(prefix string) ([]Entry, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make([]Entry, 0, len(entries))
+	removed := make([]Entry, 0)
+	for _, v := range entries {
+		if !strings.HasPrefix(v.Key, prefix) {
+			kept = append(kept, v)
+			continue
+		}
+		if err := c.backend.Remove(v.id()); err != nil {
+			return removed, err
+		}
+		removed = append(removed, v)
+	}
+	return removed, c.writeIndex(kept)
+}
+
+// Clear deletes every entry.
+func (c *Cache) Clear() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.backend.Clear()
+}
+
+func (c *Cache) readIndex() ([]Entry, error) {
+	r, err := c.backend.Read(indexName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make([]Entry, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries := make([]Entry, 0)
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("cache: could not read the index: %v", err)
+	}
+	return entries, nil
+}
+
+func (c *Cache) writeIndex(entries []Entry) error {
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.backend.Write(indexName, strings.NewReader(string(b)))
+}
+
+func removeKey(entries []Entry, key string) []Entry {
+	kept := make([]Entry, 0, len(entries))
+	for _, v := range entries {
+		if v.Key != key {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
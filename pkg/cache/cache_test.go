@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func save(t *testing.T, c *Cache, key string, contents map[string]string) {
+	paths := make([]string, 0, len(contents))
+	for k := range contents {
+		paths = append(paths, k)
+	}
+	_, err := c.Save(key, paths, func(path string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(contents[path])), nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestCache(t *testing.T) {
+	c := New(NewDirBackend(t.TempDir()))
+
+	_, err := c.Find("go-abc", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	save(t, c, "go-abc", map[string]string{"/root/go/pkg/mod": "modules"})
+	save(t, c, "go-def", map[string]string{"/root/go/pkg/mod": "newer modules"})
+	save(t, c, "npm-abc", map[string]string{"/app/node_modules": "packages"})
+
+	entry, err := c.Find("go-abc", []string{"go-"})
+	assert.NoError(t, err)
+	assert.Equal(t, "go-abc", entry.Key)
+	assert.Equal(t, int64(len("modules")), entry.Size)
+
+	entry, err = c.Find("go-xyz", []string{"go-"})
+	assert.NoError(t, err)
+	assert.Equal(t, "go-def", entry.Key)
+
+	r, err := c.Open(entry, "/root/go/pkg/mod")
+	assert.NoError(t, err)
+	b, err := io.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "newer modules", string(b))
+
+	_, err = c.Open(entry, "/app/node_modules")
+	assert.Error(t, err)
+
+	entries, err := c.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "npm-abc", entries[0].Key)
+
+	removed, err := c.Remove("go-")
+	assert.NoError(t, err)
+	assert.Len(t, removed, 2)
+	_, err = c.Find("go-xyz", []string{"go-"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, c.Clear())
+	entries, err = c.List()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDirBackendClear(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644))
+	c := New(NewDirBackend(dir))
+	assert.Error(t, c.Clear(), "a directory without an index should not be cleared")
+
+	save(t, c, "go-abc", map[string]string{"/root/go/pkg/mod": "modules", "/root/.cache/go-build": "build"})
+	entry, err := c.Find("go-abc", nil)
+	assert.NoError(t, err)
+	save(t, c, "go-abc", map[string]string{"/root/go/pkg/mod": "modules"})
+	_, err = os.Stat(filepath.Join(dir, entry.id()))
+	assert.ErrorIs(t, err, fs.ErrNotExist, "archives of the previous entry should be removed")
+	entry, err = c.Find("go-abc", nil)
+	assert.NoError(t, err)
+	archives, err := os.ReadDir(filepath.Join(dir, entry.id()))
+	assert.NoError(t, err)
+	assert.Len(t, archives, 1)
+
+	assert.NoError(t, c.Clear())
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "main.go", files[0].Name())
+}
+
+func TestSaveConcurrent(t *testing.T) {
+	c := New(NewDirBackend(t.TempDir()))
+	save(t, c, "go-abc", map[string]string{"/root/go/pkg/mod": "modules"})
+	entry, err := c.Find("go-abc", nil)
+	assert.NoError(t, err)
+
+	// A restore that found the entry before another job saved the key keeps reading the same archive.
+	r, err := c.Open(entry, "/root/go/pkg/mod")
+	assert.NoError(t, err)
+	defer r.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			save(t, c, "go-abc", map[string]string{"/root/go/pkg/mod": fmt.Sprintf("modules %d", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "modules", string(b))
+
+	entries, err := c.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	r, err = c.Open(entries[0], "/root/go/pkg/mod")
+	assert.NoError(t, err)
+	b, err = io.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^modules \d$`, string(b), "the entry should point to complete archives")
+}
+
+func TestRenderKey(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.sum"), []byte("v1"), 0644))
+
+	key, err := RenderKey(`go-{{ env "GOOS" }}-{{ hashFiles "go.sum" }}`, dir, map[string]string{"GOOS": "linux"})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^go-linux-[0-9a-f]{16}$`, key)
+
+	same, err := RenderKey(`go-{{ env "GOOS" }}-{{ hashFiles "*.sum" }}`, dir, map[string]string{"GOOS": "linux"})
+	assert.NoError(t, err)
+	assert.Equal(t, key, same)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.sum"), []byte("v2"), 0644))
+	changed, err := RenderKey(`go-{{ env "GOOS" }}-{{ hashFiles "go.sum" }}`, dir, map[string]string{"GOOS": "linux"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, changed)
+
+	_, err = RenderKey(`go-{{ hashFiles "package-lock.json" }}`, dir, nil)
+	assert.ErrorContains(t, err, "no files match package-lock.json")
+
+	assert.Error(t, ValidateKey(`go-{{ hashFiles "go.sum" `))
+	assert.NoError(t, ValidateKey(`go-{{ hashFiles "go.sum" }}`))
+}
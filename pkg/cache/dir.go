@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// entryDir matches the names of the directories that hold the archives of an entry.
+var entryDir = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// DirBackend keeps the cache in a directory on the host.
+type DirBackend struct {
+	dir string
+}
+
+func NewDirBackend(dir string) *DirBackend {
+	return &DirBackend{dir: dir}
+}
+
+func (d *DirBackend) Read(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, filepath.FromSlash(name)))
+}
+
+// Write writes to a temporary file that replaces the file once it is complete, so an interrupted
+// write does not leave a truncated archive behind.
+func (d *DirBackend) Write(name string, r io.Reader) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d *DirBackend) Remove(name string) error {
+	return os.RemoveAll(filepath.Join(d.dir, filepath.FromSlash(name)))
+}
+
+// Clear deletes the index and the directories of the entries. Other files are kept, and a directory
+// without an index is refused, so pointing --cache-dir at the wrong directory does not delete it.
+func (d *DirBackend) Clear() error {
+	index := filepath.Join(d.dir, indexName)
+	if _, err := os.Stat(index); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if _, err := os.Stat(d.dir); errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("cache: %s is not a cache directory, it has no %s", d.dir, indexName)
+		}
+		return err
+	}
+
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, v := range files {
+		if v.IsDir() && entryDir.MatchString(v.Name()) {
+			if err := os.RemoveAll(filepath.Join(d.dir, v.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(index); err != nil {
+		return err
+	}
+	// The directory is only removed if nothing else is left in it.
+	os.Remove(d.dir)
+	return nil
+}
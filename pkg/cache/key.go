@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// RenderKey executes the key template. Templates can use {{ hashFiles "go.sum" }}, which hashes the
+// files matching the patterns relative to dir, and {{ env "NAME" }}, which returns a job variable.
+func RenderKey(key, dir string, env map[string]string) (string, error) {
+	t, err := parseKey(key, template.FuncMap{
+		"hashFiles": func(patterns ...string) (string, error) {
+			return hashFiles(dir, patterns)
+		},
+		"env": func(name string) string {
+			return env[name]
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, nil); err != nil {
+		return "", fmt.Errorf("could not render cache key %s: %v", key, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// ValidateKey checks that the key is a valid template, without rendering it.
+func ValidateKey(key string) error {
+	_, err := parseKey(key, template.FuncMap{
+		"hashFiles": func(patterns ...string) string { return "" },
+		"env":       func(name string) string { return "" },
+	})
+	return err
+}
+
+func parseKey(key string, funcs template.FuncMap) (*template.Template, error) {
+	t, err := template.New("key").Option("missingkey=error").Funcs(funcs).Parse(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache key %s: %v", key, err)
+	}
+	return t, nil
+}
+
+// hashFiles returns the sha256 of the names and contents of the files matching the patterns. It fails
+// if no file matches, so a typo does not silently produce a constant key.
+func hashFiles(dir string, patterns []string) (string, error) {
+	files := make([]string, 0)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return "", err
+		}
+		for _, v := range matches {
+			if info, err := os.Stat(v); err == nil && info.Mode().IsRegular() {
+				files = append(files, v)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no files match %s", strings.Join(patterns, ", "))
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, v := range files {
+		rel, err := filepath.Rel(dir, v)
+		if err != nil {
+			return "", err
+		}
+		f, err := os.Open(v)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/opnlabs/dot/pkg/engine"
+)
+
+const volumeMountPoint = "/cache"
+
+// VolumeBackend keeps the cache in a named volume of the container engine. The volume is accessed by
+// copying files to and from a helper container that mounts it and is never started. The engine API
+// cannot delete single files, so Remove runs a short-lived container from the same image instead.
+type VolumeBackend struct {
+	cli    *client.Client
+	volume string
+	image  string
+
+	lock     sync.Mutex
+	helperID string
+}
+
+// NewVolumeBackend uses the volume with the given name. The helper container is created from image,
+// which is pulled if it is not present.
+func NewVolumeBackend(e engine.Engine, volume, image string) (*VolumeBackend, error) {
+	cli, err := engine.NewClient(e)
+	if err != nil {
+		return nil, err
+	}
+	return &VolumeBackend{cli: cli, volume: volume, image: image}, nil
+}
+
+func (v *VolumeBackend) Read(name string) (io.ReadCloser, error) {
+	id, err := v.helper()
+	if err != nil {
+		return nil, err
+	}
+
+	r, _, err := v.cli.CopyFromContainer(context.Background(), id, path.Join(volumeMountPoint, name))
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	if _, err := tr.Next(); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("could not read %s from volume %s: %v", name, v.volume, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, r}, nil
+}
+
+// Write stages the contents in a temporary file, since the size is needed to create the archive that is
+// copied into the volume.
+func (v *VolumeBackend) Write(name string, r io.Reader) error {
+	id, err := v.helper()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "dot-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		dirs := strings.Split(path.Dir(name), "/")
+		for i := range dirs {
+			if dirs[i] == "." {
+				continue
+			}
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: strings.Join(dirs[:i+1], "/") + "/", Mode: 0755}); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size}); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(tw, f); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+
+	return v.cli.CopyToContainer(context.Background(), id, volumeMountPoint, pr, types.CopyToContainerOptions{})
+}
+
+// Remove runs rm in a container that mounts the volume, and waits for it to exit.
+func (v *VolumeBackend) Remove(name string) error {
+	target := path.Join(volumeMountPoint, path.Clean("/"+name))
+	if target == volumeMountPoint {
+		return fmt.Errorf("cache: refusing to remove the root of volume %s", v.volume)
+	}
+
+	ctx := context.Background()
+	id, err := v.createContainer(ctx, []string{"rm", "-rf", "--", target})
+	if err != nil {
+		return err
+	}
+	defer v.cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true})
+
+	if err := v.cli.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("could not remove %s from volume %s: %v", name, v.volume, err)
+	}
+	statusCh, errCh := v.cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("could not remove %s from volume %s: %v", name, v.volume, err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("could not remove %s from volume %s: rm exited with status code %d", name, v.volume, status.StatusCode)
+		}
+	}
+	return nil
+}
+
+// Clear removes the volume.
+func (v *VolumeBackend) Clear() error {
+	if err := v.Close(); err != nil {
+		return err
+	}
+	err := v.cli.VolumeRemove(context.Background(), v.volume, true)
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Close removes the helper container.
+func (v *VolumeBackend) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if len(v.helperID) == 0 {
+		return nil
+	}
+	err := v.cli.ContainerRemove(context.Background(), v.helperID, types.ContainerRemoveOptions{Force: true})
+	v.helperID = ""
+	return err
+}
+
+func (v *VolumeBackend) helper() (string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if len(v.helperID) > 0 {
+		return v.helperID, nil
+	}
+
+	id, err := v.createContainer(context.Background(), nil)
+	if err != nil {
+		return "", err
+	}
+	v.helperID = id
+	return v.helperID, nil
+}
+
+// createContainer creates a container that mounts the volume and runs cmd, or the command of the image
+// if cmd is nil. The image is pulled if it is not present.
+func (v *VolumeBackend) createContainer(ctx context.Context, cmd []string) (string, error) {
+	if _, _, err := v.cli.ImageInspectWithRaw(ctx, v.image); err != nil {
+		reader, err := v.cli.ImagePull(ctx, v.image, types.ImagePullOptions{})
+		if err != nil {
+			return "", fmt.Errorf("could not pull cache helper image %s: %v", v.image, err)
+		}
+		_, err = io.Copy(io.Discard, reader)
+		reader.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resp, err := v.cli.ContainerCreate(ctx, &container.Config{
+		Image:  v.image,
+		Cmd:    cmd,
+		Labels: map[string]string{engine.Label: "cache"},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: v.volume, Target: volumeMountPoint}},
+	}, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("could not create cache helper container: %v", err)
+	}
+	return resp.ID, nil
+}
@@ -0,0 +1,118 @@
+// Package engine creates clients for the container engine that runs the jobs.
+//
+// Docker and Podman are supported. Podman is used through its Docker compatible API socket,
+// which is discovered in the usual rootless and rootful locations.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+type Engine string
+
+const (
+	Docker Engine = "docker"
+	Podman Engine = "podman"
+	// Auto uses docker if it is configured or its socket exists and falls back to podman.
+	Auto Engine = "auto"
+
+	DockerSocket = "/var/run/docker.sock"
+	// Label is set on every container and network created by dot.
+	Label = "io.opnlabs.dot.job"
+)
+
+var (
+	ErrUnknownEngine  = errors.New("engine: unknown container engine")
+	ErrSocketNotFound = errors.New("engine: podman socket not found")
+)
+
+// NewClient creates a Docker API client for the engine.
+func NewClient(e Engine) (*client.Client, error) {
+	host, err := Host(e)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if len(host) > 0 {
+		opts = append(opts, client.WithHost(host))
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// Host returns the daemon host for the engine.
+// An empty host means the client defaults and DOCKER_HOST are used.
+func Host(e Engine) (string, error) {
+	switch e {
+	case "", Docker:
+		return "", nil
+	case Podman:
+		if host := os.Getenv("CONTAINER_HOST"); len(host) > 0 {
+			return host, nil
+		}
+		if path, ok := podmanSocket(); ok {
+			return "unix://" + path, nil
+		}
+		return "", ErrSocketNotFound
+	case Auto:
+		if len(os.Getenv(client.EnvOverrideHost)) > 0 {
+			return "", nil
+		}
+		if _, err := os.Stat(DockerSocket); err == nil {
+			return "", nil
+		}
+		if path, ok := podmanSocket(); ok {
+			return "unix://" + path, nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownEngine, e)
+}
+
+// SocketPath returns the path of the unix socket used by cli so it can be mounted into a container.
+func SocketPath(cli *client.Client) string {
+	if host := cli.DaemonHost(); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return DockerSocket
+}
+
+// IsRootless reports whether the engine runs without root, in which case the root user inside
+// the container is mapped to the user that started the engine.
+func IsRootless(ctx context.Context, cli *client.Client) (bool, error) {
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range info.SecurityOptions {
+		if strings.Contains(v, "name=rootless") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func podmanSocket() (string, bool) {
+	candidates := make([]string, 0)
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		candidates = append(candidates, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	candidates = append(candidates,
+		filepath.Join("/run/user", fmt.Sprint(os.Getuid()), "podman", "podman.sock"),
+		"/run/podman/podman.sock",
+	)
+
+	for _, v := range candidates {
+		if info, err := os.Stat(v); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return v, true
+		}
+	}
+	return "", false
+}
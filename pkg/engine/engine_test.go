@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodmanSocketDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("CONTAINER_HOST", "")
+
+	socket := filepath.Join(dir, "podman", "podman.sock")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(socket), 0755))
+	l, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	host, err := Host(Podman)
+	assert.NoError(t, err)
+	assert.Equal(t, "unix://"+socket, host)
+
+	cli, err := NewClient(Podman)
+	assert.NoError(t, err)
+	defer cli.Close()
+	assert.Equal(t, socket, SocketPath(cli))
+}
+
+func TestContainerHost(t *testing.T) {
+	t.Setenv("CONTAINER_HOST", "unix:///tmp/testing.sock")
+
+	host, err := Host(Podman)
+	assert.NoError(t, err)
+	assert.Equal(t, "unix:///tmp/testing.sock", host)
+}
+
+func TestDockerHost(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "unix:///tmp/docker-testing.sock")
+
+	host, err := Host(Auto)
+	assert.NoError(t, err)
+	assert.Empty(t, host)
+
+	cli, err := NewClient(Docker)
+	assert.NoError(t, err)
+	defer cli.Close()
+	assert.Equal(t, "/tmp/docker-testing.sock", SocketPath(cli))
+}
+
+func TestUnknownEngine(t *testing.T) {
+	_, err := Host("containerd")
+	assert.ErrorIs(t, err, ErrUnknownEngine)
+}
@@ -1,5 +1,13 @@
 package models
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Stage string
 
 // Variable represents a job variable as a key-value pair.
@@ -10,6 +18,8 @@ type Variable map[string]any
 type JobFile struct {
 	Stages []Stage `yaml:"stages" validate:"required,dive"`
 	Jobs   []Job   `yaml:"jobs" validate:"required,dive"`
+	// Timeout is the default timeout of the jobs that do not set one.
+	Timeout time.Duration `yaml:"timeout" validate:"gte=0"`
 }
 
 // Job represents a single job in a stage
@@ -18,9 +28,232 @@ type Job struct {
 	Src        string     `yaml:"src"`
 	Stage      Stage      `yaml:"stage" validate:"required"`
 	Variables  []Variable `yaml:"variables"`
-	Image      string     `yaml:"image" validate:"required"`
+	Image      string     `yaml:"image" validate:"required_unless=Runner shell"`
 	Script     []string   `yaml:"script"`
 	Entrypoint []string   `yaml:"entrypoint"`
-	Artifacts  []string   `yaml:"artifacts"`
+	Artifacts  []Artifact `yaml:"artifacts" validate:"dive"`
 	Condition  string     `yaml:"condition"`
+	Needs      []string   `yaml:"needs"`
+	Matrix     *Matrix    `yaml:"matrix"`
+	Runner     string     `yaml:"runner"`
+	// Dependencies are the jobs or artifact names whose artifacts are copied into the job. All the
+	// artifacts of the earlier jobs are copied if it is not set, and none if it is empty.
+	Dependencies []string `yaml:"dependencies"`
+	// Timeout stops the job when it runs for longer. Zero uses the default timeout.
+	Timeout time.Duration `yaml:"timeout" validate:"gte=0"`
+	Retry   *Retry        `yaml:"retry"`
+	// AllowFailure lets the pipeline pass when the job fails.
+	AllowFailure AllowFailure `yaml:"allow_failure"`
+	// When decides if the job runs depending on the outcome of the jobs it depends on.
+	When When `yaml:"when" validate:"omitempty,oneof=on_success on_failure always manual"`
+	// Services are started next to the job and reachable by their alias.
+	Services []Service `yaml:"services" validate:"dive"`
+	// Cache is restored before the job starts and saved after it succeeds.
+	Cache *Cache `yaml:"cache"`
+}
+
+// Artifact is a path published by a job. In the job file it is the path, or a mapping with a name, a path
+// and the paths to exclude.
+type Artifact struct {
+	// Name is used in the dependencies of other jobs. It defaults to the name of the job.
+	Name string `yaml:"name"`
+	// Path is relative to the working directory. It can be a glob pattern where ** matches any number
+	// of directories.
+	Path string `yaml:"path" validate:"required"`
+	// Exclude lists the patterns of the files and directories left out of the artifact.
+	Exclude []string `yaml:"exclude"`
+	// Optional skips the artifact when its path matches no files instead of failing the job.
+	Optional bool `yaml:"optional"`
+}
+
+func (a *Artifact) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&a.Path)
+	}
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		switch key := value.Content[i]; key.Value {
+		case "name", "path", "exclude", "optional":
+		default:
+			return fmt.Errorf("line %d: unknown field %s in artifacts", key.Line, key.Value)
+		}
+	}
+
+	var v struct {
+		Name     string   `yaml:"name"`
+		Path     string   `yaml:"path"`
+		Exclude  []string `yaml:"exclude"`
+		Optional bool     `yaml:"optional"`
+	}
+	if err := value.Decode(&v); err != nil {
+		return err
+	}
+	*a = Artifact(v)
+	return nil
+}
+
+// Publishes returns true if the job publishes an artifact with the given name. Artifacts without a
+// name are published under the name of the job.
+func (j Job) Publishes(name string) bool {
+	for _, v := range j.Artifacts {
+		if v.Name == name || (len(v.Name) == 0 && j.Name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cache keeps paths of the job, such as downloaded dependencies, between runs.
+type Cache struct {
+	// Key names the contents of the cache. It is a template where {{ hashFiles "go.sum" }} is replaced
+	// by the hash of the files and {{ env "NAME" }} by the value of a variable.
+	Key string `yaml:"key" validate:"required"`
+	// FallbackKeys are used in order when nothing is stored under Key. The most recent cache whose key
+	// starts with a fallback key is restored. They are templates like Key.
+	FallbackKeys []string `yaml:"fallback_keys"`
+	// Paths are the directories and files that are cached, relative to the working directory or absolute.
+	Paths []string `yaml:"paths" validate:"required,min=1"`
+}
+
+// Service is a container that runs next to a job, like a database used by integration tests.
+type Service struct {
+	Image string `yaml:"image" validate:"required"`
+	// Alias is the host name of the service. It defaults to the name of the image without the
+	// registry, path and tag.
+	Alias       string       `yaml:"alias"`
+	Variables   []Variable   `yaml:"variables"`
+	Entrypoint  []string     `yaml:"entrypoint"`
+	Command     []string     `yaml:"command"`
+	Healthcheck *Healthcheck `yaml:"healthcheck"`
+}
+
+// Hostname returns the alias of the service, or the name derived from its image.
+func (s Service) Hostname() string {
+	if len(s.Alias) > 0 {
+		return s.Alias
+	}
+	name := s.Image
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// Healthcheck decides when a service is ready. The job starts once all of its services are healthy.
+type Healthcheck struct {
+	// Test is the command run inside the service container. It is run with the shell if it has
+	// a single element.
+	Test        []string      `yaml:"test" validate:"required"`
+	Interval    time.Duration `yaml:"interval" validate:"gte=0"`
+	Timeout     time.Duration `yaml:"timeout" validate:"gte=0"`
+	StartPeriod time.Duration `yaml:"start_period" validate:"gte=0"`
+	Retries     int           `yaml:"retries" validate:"gte=0"`
+}
+
+// When decides if a job runs.
+type When string
+
+const (
+	// WhenOnSuccess runs the job when none of the jobs it depends on failed. It is the default.
+	WhenOnSuccess When = "on_success"
+	// WhenOnFailure runs the job when one of the jobs it depends on failed.
+	WhenOnFailure When = "on_failure"
+	// WhenAlways runs the job whatever the outcome of the jobs it depends on.
+	WhenAlways When = "always"
+	// WhenManual runs the job only when it is selected with --job.
+	WhenManual When = "manual"
+)
+
+// AllowFailure is decoded from a boolean, or from a map with the exit codes that are allowed to fail.
+type AllowFailure struct {
+	Enabled bool `yaml:"-"`
+	// ExitCodes limits the allowed failures to these exit codes.
+	ExitCodes []int `yaml:"exit_codes"`
+}
+
+// UnmarshalYAML decodes allow_failure: true as well as allow_failure: {exit_codes: [1]}.
+func (a *AllowFailure) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&a.Enabled)
+	}
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if key := value.Content[i]; key.Value != "exit_codes" {
+			return fmt.Errorf("line %d: unknown field %s in allow_failure", key.Line, key.Value)
+		}
+	}
+
+	var v struct {
+		ExitCodes []int `yaml:"exit_codes"`
+	}
+	if err := value.Decode(&v); err != nil {
+		return err
+	}
+	a.Enabled = true
+	a.ExitCodes = v.ExitCodes
+	return nil
+}
+
+// Failure classes that can be retried.
+const (
+	RetryImagePull = "image_pull"
+	RetryExitCode  = "exit_code"
+	RetryTimeout   = "timeout"
+)
+
+// Retry runs a failed job again.
+type Retry struct {
+	// Attempts is the maximum number of times the job runs, including the first run.
+	Attempts int `yaml:"attempts" validate:"gte=1"`
+	// Backoff is the wait before the second attempt. It doubles after every attempt.
+	Backoff time.Duration `yaml:"backoff" validate:"gte=0"`
+	// On lists the failure classes that are retried. All of them are retried if it is empty.
+	On []string `yaml:"on" validate:"dive,oneof=image_pull exit_code timeout"`
+	// ExitCodes limits the exit_code class to these codes.
+	ExitCodes []int `yaml:"exit_codes"`
+}
+
+// MatrixAxis is a matrix variable and the list of values it takes.
+type MatrixAxis struct {
+	Name   string
+	Values []any
+}
+
+// Matrix expands a job into one job per combination of the axis values.
+// Include adds extra combinations and Exclude removes the combinations that match all of its entries.
+type Matrix struct {
+	Axes    []MatrixAxis
+	Include []map[string]any
+	Exclude []map[string]any
+}
+
+// UnmarshalYAML decodes a matrix while preserving the order in which the variables are defined.
+func (m *Matrix) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: matrix should be a map of variables to lists of values", value.Line)
+	}
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i], value.Content[i+1]
+		switch key.Value {
+		case "include":
+			if err := val.Decode(&m.Include); err != nil {
+				return err
+			}
+		case "exclude":
+			if err := val.Decode(&m.Exclude); err != nil {
+				return err
+			}
+		default:
+			axis := MatrixAxis{Name: key.Value}
+			if err := val.Decode(&axis.Values); err != nil {
+				return fmt.Errorf("line %d: matrix variable %s should be a list of values: %v", val.Line, key.Value, err)
+			}
+			m.Axes = append(m.Axes, axis)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// Evaluate runs the condition of the job with the job variables and the extra variables as the environment.
+// The extra variables, usually passed on the command line, take precedence over the job variables.
+// A job without a condition always evaluates to true.
+func Evaluate(job models.Job, extra []models.Variable) (bool, error) {
+	p, env, err := compileCondition(job, extra)
+	if err != nil {
+		return false, err
+	}
+
+	output, err := expr.Run(p, env)
+	if err != nil {
+		return false, fmt.Errorf("condition evaluation failed for job %s: %v", job.Name, err)
+	}
+	return output.(bool), nil
+}
+
+// compileCondition creates the expr program for the job condition and the environment it runs with.
+func compileCondition(job models.Job, extra []models.Variable) (*vm.Program, map[string]any, error) {
+	condition := job.Condition
+	if len(condition) == 0 {
+		condition = `true`
+	}
+
+	env := make(map[string]any)
+	for _, entries := range job.Variables {
+		if len(entries) > 1 {
+			return nil, nil, fmt.Errorf("variables should be defined as a key value pair in job %s", job.Name)
+		}
+		for k, value := range entries {
+			env[k] = value
+		}
+	}
+	// The extra variables are strings. They take the type of the job variable they override, so the
+	// condition sees the same types with and without them.
+	if len(job.Condition) == 0 {
+		extra = nil
+	}
+	for _, entries := range extra {
+		if len(entries) > 1 {
+			return nil, nil, fmt.Errorf("variables should be defined as a key value pair in job %s", job.Name)
+		}
+		for k, value := range entries {
+			converted, err := convertVariable(env[k], value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("variable %s of job %s: %v", k, job.Name, err)
+			}
+			env[k] = converted
+		}
+	}
+
+	p, err := expr.Compile(condition, expr.Env(env), expr.AsBool())
+	if err != nil {
+		return nil, nil, fmt.Errorf("condition evaluation failed for job %s: %v", job.Name, err)
+	}
+	return p, env, nil
+}
+
+// convertVariable converts a string value to the type of the job variable it overrides. Other values
+// and values of variables the job does not define are returned as they are.
+func convertVariable(current, value any) (any, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+
+	var err error
+	switch current.(type) {
+	case bool:
+		if value, err = strconv.ParseBool(str); err != nil {
+			return nil, fmt.Errorf("expected a bool to override %v, got %q", current, str)
+		}
+	case int:
+		if value, err = strconv.Atoi(str); err != nil {
+			return nil, fmt.Errorf("expected an int to override %v, got %q", current, str)
+		}
+	case float64:
+		if value, err = strconv.ParseFloat(str, 64); err != nil {
+			return nil, fmt.Errorf("expected a float to override %v, got %q", current, str)
+		}
+	}
+	return value, nil
+}
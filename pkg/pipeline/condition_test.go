@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateOverride(t *testing.T) {
+	job := models.Job{Name: "deploy", Variables: []models.Variable{{"DEPLOY": false}, {"REPLICAS": 1}}}
+	tests := []struct {
+		condition string
+		extra     []models.Variable
+		expected  bool
+	}{
+		{"DEPLOY", nil, false},
+		{"DEPLOY", []models.Variable{{"DEPLOY": "true"}}, true},
+		{"DEPLOY == true", []models.Variable{{"DEPLOY": "true"}}, true},
+		{"REPLICAS > 2", []models.Variable{{"REPLICAS": "3"}}, true},
+		{`TARGET == "prod"`, []models.Variable{{"TARGET": "prod"}}, true},
+	}
+
+	for _, v := range tests {
+		job.Condition = v.condition
+		ok, err := Evaluate(job, v.extra)
+		assert.NoError(t, err, v.condition)
+		assert.Equal(t, v.expected, ok, v.condition)
+	}
+
+	job.Condition = "DEPLOY"
+	_, err := Evaluate(job, []models.Variable{{"DEPLOY": "yes please"}})
+	assert.ErrorContains(t, err, `variable DEPLOY of job deploy: expected a bool to override false, got "yes please"`)
+
+	job.Condition = ""
+	ok, err := Evaluate(job, []models.Variable{{"DEPLOY": "yes please"}})
+	assert.NoError(t, err, "variables should only be converted for conditions")
+	assert.True(t, ok)
+}
+
+func TestParseConditionOverride(t *testing.T) {
+	jobFile := `stages: [deploy]
+jobs:
+  - name: deploy
+    stage: deploy
+    image: alpine
+    variables:
+      - DEPLOY: false
+    condition: DEPLOY
+`
+	_, err := Parse("dot.yml", []byte(jobFile), []models.Variable{{"DEPLOY": "true"}})
+	assert.NoError(t, err)
+
+	_, err = Parse("dot.yml", []byte(jobFile), []models.Variable{{"DEPLOY": "maybe"}})
+	assert.ErrorContains(t, err, `dot.yml:8:16: variable DEPLOY of job deploy: expected a bool to override false, got "maybe"`)
+}
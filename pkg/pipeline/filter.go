@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// Skipped is a job that will not run and the reason why.
+type Skipped struct {
+	Job    models.Job
+	Reason string
+}
+
+// Filter selects a subset of the jobs in a graph.
+type Filter struct {
+	// Jobs are the names of the jobs to run. The name of a matrix job selects all the jobs created from it.
+	Jobs []string
+	// Stages are the stages to run.
+	Stages []models.Stage
+	// FromStage runs the stage and all the stages after it.
+	FromStage models.Stage
+	// WithProducers also runs the upstream jobs that publish the artifacts used by the selected jobs.
+	WithProducers bool
+}
+
+// IsEmpty returns true if the filter selects every job.
+func (f Filter) IsEmpty() bool {
+	return len(f.Jobs) == 0 && len(f.Stages) == 0 && len(f.FromStage) == 0
+}
+
+// Select returns a graph with the jobs matched by every criteria in the filter and the list of jobs
+// that were left out. Manual jobs are left out unless they are selected by name.
+func (g *Graph) Select(f Filter) (*Graph, []Skipped, error) {
+	if f.IsEmpty() && !g.hasManualJobs() {
+		return g, nil, nil
+	}
+
+	stageIndex := make(map[models.Stage]int)
+	for i, v := range g.stages {
+		stageIndex[v] = i
+	}
+	for _, v := range append(append([]models.Stage{}, f.Stages...), f.FromStage) {
+		if _, ok := stageIndex[v]; len(v) > 0 && !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownStage, v)
+		}
+	}
+
+	for _, name := range f.Jobs {
+		found := false
+		for _, job := range g.jobs {
+			if matchName(job.Name, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownJob, name)
+		}
+	}
+
+	selected := make(map[string]bool)
+	reasons := make(map[string]string)
+	for _, job := range g.jobs {
+		switch {
+		case job.When == models.WhenManual && !matchAny(job.Name, f.Jobs):
+			reasons[job.Name] = "manual job, select it with --job to run it"
+		case len(f.Jobs) > 0 && !matchAny(job.Name, f.Jobs):
+			reasons[job.Name] = "not selected by --job"
+		case len(f.Stages) > 0 && !containsStage(f.Stages, job.Stage):
+			reasons[job.Name] = fmt.Sprintf("stage %s not selected by --stage", job.Stage)
+		case len(f.FromStage) > 0 && stageIndex[job.Stage] < stageIndex[f.FromStage]:
+			reasons[job.Name] = fmt.Sprintf("stage %s is before %s", job.Stage, f.FromStage)
+		default:
+			selected[job.Name] = true
+		}
+	}
+
+	if f.WithProducers {
+		for _, job := range g.jobs {
+			if selected[job.Name] {
+				g.addProducers(job.Name, selected, make(map[string]bool))
+			}
+		}
+	}
+
+	jobs := make([]models.Job, 0)
+	skipped := make([]Skipped, 0)
+	for _, job := range g.jobs {
+		if selected[job.Name] {
+			jobs = append(jobs, job)
+			continue
+		}
+		skipped = append(skipped, Skipped{Job: job, Reason: reasons[job.Name]})
+	}
+
+	selectedGraph, err := NewGraph(g.stages, jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return selectedGraph, skipped, nil
+}
+
+func (g *Graph) hasManualJobs() bool {
+	for _, job := range g.jobs {
+		if job.When == models.WhenManual {
+			return true
+		}
+	}
+	return false
+}
+
+// addProducers selects the upstream jobs that publish the artifacts used by name, and the producers
+// of those jobs in turn. Jobs without dependencies use the artifacts of every upstream job.
+func (g *Graph) addProducers(name string, selected, visited map[string]bool) {
+	job := g.jobs[g.index[name]]
+	for _, dep := range g.upstream(name) {
+		producer := g.jobs[g.index[dep]]
+		if visited[dep] || len(producer.Artifacts) == 0 || !uses(job, producer) {
+			continue
+		}
+		visited[dep] = true
+		selected[dep] = true
+		g.addProducers(dep, selected, visited)
+	}
+}
+
+// uses returns true if the job receives the artifacts of the producer.
+func uses(job, producer models.Job) bool {
+	if job.Dependencies == nil {
+		return true
+	}
+	for _, v := range job.Dependencies {
+		if v == producer.Name || producer.Publishes(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchName(jobName, name string) bool {
+	return jobName == name || strings.HasPrefix(jobName, name+" (")
+}
+
+func matchAny(jobName string, names []string) bool {
+	for _, v := range names {
+		if matchName(jobName, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStage(stages []models.Stage, stage models.Stage) bool {
+	for _, v := range stages {
+		if v == stage {
+			return true
+		}
+	}
+	return false
+}
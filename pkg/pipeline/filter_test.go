@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func filterGraph(t *testing.T) *Graph {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "lint", Stage: "test"},
+		{Name: "compile", Stage: "test", Artifacts: []models.Artifact{{Path: "bin"}}},
+		{Name: "unit (1.21)", Stage: "test"},
+		{Name: "unit (1.22)", Stage: "test"},
+		{Name: "package", Stage: "build", Needs: []string{"compile"}},
+		{Name: "deploy", Stage: "deploy"},
+	})
+	assert.NoError(t, err)
+	return g
+}
+
+func names(g *Graph) []string {
+	n := make([]string, 0)
+	for _, job := range g.Jobs() {
+		n = append(n, job.Name)
+	}
+	return n
+}
+
+func TestSelectJobs(t *testing.T) {
+	g, skipped, err := filterGraph(t).Select(Filter{Jobs: []string{"package", "unit"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unit (1.21)", "unit (1.22)", "package"}, names(g))
+	assert.Len(t, skipped, 3)
+	assert.Equal(t, "not selected by --job", skipped[0].Reason)
+
+	g, _, err = filterGraph(t).Select(Filter{Jobs: []string{"deploy"}, WithProducers: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"compile", "deploy"}, names(g))
+	assert.ElementsMatch(t, []string{"compile"}, g.Dependencies("deploy"))
+}
+
+func TestSelectDependencies(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "compile", Stage: "test", Artifacts: []models.Artifact{{Path: "bin"}}},
+		{Name: "docs", Stage: "test", Artifacts: []models.Artifact{{Name: "site", Path: "public"}}},
+		{Name: "coverage", Stage: "test", Artifacts: []models.Artifact{{Path: "coverage.out"}}},
+		{Name: "package", Stage: "build", Dependencies: []string{"compile"}},
+		{Name: "publish", Stage: "deploy", Dependencies: []string{"site"}},
+		{Name: "notify", Stage: "deploy", Dependencies: []string{}},
+	})
+	assert.NoError(t, err)
+
+	selected, _, err := g.Select(Filter{Jobs: []string{"publish"}, WithProducers: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docs", "publish"}, names(selected))
+
+	selected, _, err = g.Select(Filter{Stages: []models.Stage{"deploy"}, WithProducers: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docs", "publish", "notify"}, names(selected))
+
+	selected, _, err = g.Select(Filter{Jobs: []string{"package", "notify"}, WithProducers: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"compile", "package", "notify"}, names(selected))
+}
+
+func TestSelectStages(t *testing.T) {
+	g, skipped, err := filterGraph(t).Select(Filter{Stages: []models.Stage{"build"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"package"}, names(g))
+	assert.Len(t, skipped, 5)
+
+	g, _, err = filterGraph(t).Select(Filter{FromStage: "build"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"package", "deploy"}, names(g))
+	assert.Equal(t, []string{"package"}, g.Dependencies("deploy"))
+}
+
+func TestSelectUnknown(t *testing.T) {
+	_, _, err := filterGraph(t).Select(Filter{Jobs: []string{"missing"}})
+	assert.ErrorIs(t, err, ErrUnknownJob)
+
+	_, _, err = filterGraph(t).Select(Filter{FromStage: "release"})
+	assert.ErrorIs(t, err, ErrUnknownStage)
+}
+
+func TestSelectManual(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "build", Stage: "build"},
+		{Name: "deploy", Stage: "deploy", When: models.WhenManual},
+	})
+	assert.NoError(t, err)
+
+	selected, skipped, err := g.Select(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"build"}, names(selected))
+	assert.Equal(t, "deploy", skipped[0].Job.Name)
+
+	selected, _, err = g.Select(Filter{Jobs: []string{"deploy"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deploy"}, names(selected))
+}
@@ -0,0 +1,184 @@
+// Package pipeline resolves the jobs in a job file into a dependency graph and schedules them.
+//
+// Jobs that declare needs start as soon as the jobs they need succeed. Jobs without needs
+// wait for every job in the earlier stages.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+var (
+	ErrDuplicateJob = errors.New("pipeline: duplicate job name")
+	ErrUnknownJob   = errors.New("pipeline: unknown job")
+	ErrUnknownStage = errors.New("pipeline: stage not defined")
+	ErrCycle        = errors.New("pipeline: dependency cycle")
+)
+
+// Graph holds the jobs that will be executed and the jobs each of them waits on.
+type Graph struct {
+	stages []models.Stage
+	jobs   []models.Job
+	index  map[string]int
+	deps   map[string][]string
+}
+
+// NewGraph creates the dependency graph for the given jobs.
+// Needs that refer to jobs not present in jobs, for example jobs excluded by their condition,
+// are treated as satisfied.
+func NewGraph(stages []models.Stage, jobs []models.Job) (*Graph, error) {
+	g, err := newGraph(stages, jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	if cycle := g.findCycle(); len(cycle) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCycle, strings.Join(cycle, " -> "))
+	}
+	return g, nil
+}
+
+// newGraph creates the dependency graph without checking it for cycles.
+func newGraph(stages []models.Stage, jobs []models.Job) (*Graph, error) {
+	stageIndex := make(map[models.Stage]int)
+	for i, v := range stages {
+		stageIndex[v] = i
+	}
+
+	byStage := make([][]models.Job, len(stages))
+	for _, job := range jobs {
+		i, ok := stageIndex[job.Stage]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownStage, job.Stage)
+		}
+		byStage[i] = append(byStage[i], job)
+	}
+
+	g := &Graph{
+		stages: stages,
+		jobs:   make([]models.Job, 0, len(jobs)),
+		index:  make(map[string]int),
+		deps:   make(map[string][]string),
+	}
+	for _, stageJobs := range byStage {
+		for _, job := range stageJobs {
+			if _, ok := g.index[job.Name]; ok {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateJob, job.Name)
+			}
+			g.index[job.Name] = len(g.jobs)
+			g.jobs = append(g.jobs, job)
+		}
+	}
+
+	previous := make([]string, 0)
+	for _, stageJobs := range byStage {
+		for _, job := range stageJobs {
+			if job.Needs == nil {
+				g.deps[job.Name] = previous
+				continue
+			}
+
+			deps := make([]string, 0, len(job.Needs))
+			for _, need := range job.Needs {
+				if _, ok := g.index[need]; ok {
+					deps = append(deps, need)
+				}
+			}
+			g.deps[job.Name] = deps
+		}
+
+		next := make([]string, len(previous), len(previous)+len(stageJobs))
+		copy(next, previous)
+		for _, job := range stageJobs {
+			next = append(next, job.Name)
+		}
+		previous = next
+	}
+
+	return g, nil
+}
+
+// Jobs returns the jobs in the graph ordered by stage.
+func (g *Graph) Jobs() []models.Job {
+	return g.jobs
+}
+
+// Dependencies returns the names of the jobs that have to succeed before the named job can start.
+func (g *Graph) Dependencies(name string) []string {
+	return g.deps[name]
+}
+
+// upstream returns the jobs that finish before the named job starts, in the order of the graph.
+func (g *Graph) upstream(name string) []string {
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		for _, dep := range g.deps[name] {
+			if !seen[dep] {
+				seen[dep] = true
+				visit(dep)
+			}
+		}
+	}
+	visit(name)
+
+	jobs := make([]string, 0, len(seen))
+	for _, job := range g.jobs {
+		if seen[job.Name] {
+			jobs = append(jobs, job.Name)
+		}
+	}
+	return jobs
+}
+
+// findCycle returns the job names that form a cycle, with the first job repeated at the end.
+// It returns nil if the graph is acyclic.
+func (g *Graph) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	path := make([]string, 0)
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range g.deps[name] {
+			switch state[dep] {
+			case visiting:
+				for i, v := range path {
+					if v == dep {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, job := range g.jobs {
+		if state[job.Name] == unvisited {
+			if cycle := visit(job.Name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStages = []models.Stage{"test", "build", "deploy"}
+
+func TestStageOrdering(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "deploy", Stage: "deploy"},
+		{Name: "unit", Stage: "test"},
+		{Name: "lint", Stage: "test"},
+		{Name: "build", Stage: "build"},
+	})
+	assert.NoError(t, err)
+
+	names := make([]string, 0)
+	for _, job := range g.Jobs() {
+		names = append(names, job.Name)
+	}
+	assert.Equal(t, []string{"unit", "lint", "build", "deploy"}, names)
+	assert.Empty(t, g.Dependencies("unit"))
+	assert.ElementsMatch(t, []string{"unit", "lint"}, g.Dependencies("build"))
+	assert.ElementsMatch(t, []string{"unit", "lint", "build"}, g.Dependencies("deploy"))
+}
+
+func TestNeeds(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "unit", Stage: "test"},
+		{Name: "lint", Stage: "test"},
+		{Name: "build", Stage: "build", Needs: []string{"unit"}},
+		{Name: "docs", Stage: "build", Needs: []string{}},
+		{Name: "deploy", Stage: "deploy", Needs: []string{"build", "skipped"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unit"}, g.Dependencies("build"))
+	assert.Empty(t, g.Dependencies("docs"))
+	assert.Equal(t, []string{"build"}, g.Dependencies("deploy"))
+}
+
+func TestCycle(t *testing.T) {
+	_, err := NewGraph(testStages, []models.Job{
+		{Name: "a", Stage: "test", Needs: []string{"c"}},
+		{Name: "b", Stage: "build", Needs: []string{"a"}},
+		{Name: "c", Stage: "deploy", Needs: []string{"b"}},
+	})
+	assert.ErrorIs(t, err, ErrCycle)
+	assert.ErrorContains(t, err, "a -> c -> b -> a")
+
+	_, err = NewGraph(testStages, []models.Job{
+		{Name: "a", Stage: "test", Needs: []string{"b"}},
+		{Name: "b", Stage: "build"},
+	})
+	assert.ErrorIs(t, err, ErrCycle)
+}
+
+func TestUnknownStage(t *testing.T) {
+	_, err := NewGraph(testStages, []models.Job{{Name: "a", Stage: "release"}})
+	assert.ErrorIs(t, err, ErrUnknownStage)
+}
+
+func runWith(registry *runner.Registry) RunFunc {
+	return func(ctx context.Context, job models.Job) error {
+		r, err := registry.New(job, runner.Options{})
+		if err != nil {
+			return err
+		}
+		return r.Run(ctx)
+	}
+}
+
+func TestRunDoesNotWaitForUnrelatedJobs(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "slow", Stage: "test"},
+		{Name: "fast", Stage: "test"},
+		{Name: "after-fast", Stage: "build", Needs: []string{"fast"}},
+		{Name: "after-all", Stage: "deploy"},
+	})
+	assert.NoError(t, err)
+
+	fake := runner.NewFake().WithDelay("slow", 100*time.Millisecond)
+	registry := runner.NewRegistry("fake")
+	registry.Register("fake", fake.Factory())
+
+	assert.NoError(t, g.Run(context.Background(), runWith(registry), nil))
+	assert.Equal(t, []string{"fast", "after-fast", "slow", "after-all"}, fake.Ran())
+}
+
+func TestRunStopsOnFailure(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "a", Stage: "test"},
+		{Name: "b", Stage: "test", Needs: []string{}},
+		{Name: "c", Stage: "build"},
+	})
+	assert.NoError(t, err)
+
+	errFailed := errors.New("failed")
+	fake := runner.NewFake().WithError("a", errFailed).WithDelay("b", 50*time.Millisecond)
+	registry := runner.NewRegistry("fake")
+	registry.Register("fake", fake.Factory())
+
+	err = g.Run(context.Background(), runWith(registry), nil)
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, []string{"a", "b"}, fake.Ran())
+}
+
+func TestRunUnknownRunner(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{{Name: "a", Stage: "test", Runner: "missing"}})
+	assert.NoError(t, err)
+
+	registry := runner.NewRegistry("fake")
+	registry.Register("fake", runner.NewFake().Factory())
+	assert.ErrorIs(t, g.Run(context.Background(), runWith(registry), nil), runner.ErrUnknownRunner)
+}
+
+func TestRunCancel(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "a", Stage: "test"},
+		{Name: "b", Stage: "test"},
+		{Name: "c", Stage: "build"},
+	})
+	assert.NoError(t, err)
+
+	fake := runner.NewFake().WithDelay("b", time.Minute)
+	registry := runner.NewRegistry("fake")
+	registry.Register("fake", fake.Factory())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = g.Run(ctx, runWith(registry), nil)
+	assert.ErrorIs(t, err, runner.ErrTimeout)
+	assert.Equal(t, []string{"a"}, fake.Ran())
+}
+
+func TestRunWhen(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "lint", Stage: "test", AllowFailure: models.AllowFailure{Enabled: true}},
+		{Name: "unit", Stage: "test"},
+		{Name: "build", Stage: "build"},
+		{Name: "notify", Stage: "deploy", When: models.WhenOnFailure},
+		{Name: "cleanup", Stage: "deploy", When: models.WhenAlways},
+		{Name: "deploy", Stage: "deploy"},
+	})
+	assert.NoError(t, err)
+
+	errFailed := errors.New("failed")
+	fake := runner.NewFake().WithError("lint", errFailed).WithError("build", errFailed)
+	registry := runner.NewRegistry("fake")
+	registry.Register("fake", fake.Factory())
+
+	summary := NewSummary(g, nil)
+	err = g.Run(context.Background(), runWith(registry), summary)
+	assert.ErrorIs(t, err, errFailed)
+	assert.ElementsMatch(t, []string{"lint", "unit", "build", "notify", "cleanup"}, fake.Ran())
+	assert.Equal(t, StatusSkipped, summary.Results()[5].Status)
+
+	fake = runner.NewFake().WithError("lint", errFailed)
+	registry.Register("fake", fake.Factory())
+	assert.NoError(t, g.Run(context.Background(), runWith(registry), nil))
+	assert.ElementsMatch(t, []string{"lint", "unit", "build", "cleanup", "deploy"}, fake.Ran())
+}
+
+func TestAllowedFailure(t *testing.T) {
+	job := models.Job{Name: "a", AllowFailure: models.AllowFailure{Enabled: true, ExitCodes: []int{3}}}
+	assert.True(t, AllowedFailure(job, fmt.Errorf("job a %w", &runner.ExitError{Code: 3})))
+	assert.False(t, AllowedFailure(job, fmt.Errorf("job a %w", &runner.ExitError{Code: 1})))
+	assert.False(t, AllowedFailure(job, errors.New("failed")))
+	assert.False(t, AllowedFailure(models.Job{Name: "b"}, errors.New("failed")))
+	assert.True(t, AllowedFailure(models.Job{Name: "c", AllowFailure: models.AllowFailure{Enabled: true}}, errors.New("failed")))
+}
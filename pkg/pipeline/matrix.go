@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+var ErrInvalidMatrix = errors.New("pipeline: invalid matrix")
+
+type matrixValue struct {
+	name  string
+	value any
+}
+
+type combination []matrixValue
+
+func (c combination) get(name string) (any, bool) {
+	for _, v := range c {
+		if v.name == name {
+			return v.value, true
+		}
+	}
+	return nil, false
+}
+
+// matches returns true if every entry in m has the same value in the combination.
+func (c combination) matches(m map[string]any) bool {
+	for k, v := range m {
+		value, ok := c.get(k)
+		if !ok || fmt.Sprint(value) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExpandMatrix returns one job per combination of the matrix variables for every job that defines a matrix.
+// Jobs without a matrix are returned unchanged. Needs and dependencies that refer to a job with a matrix
+// are replaced with the names of all the jobs created from it.
+func ExpandMatrix(jobs []models.Job) ([]models.Job, error) {
+	expanded := make([]models.Job, 0, len(jobs))
+	names := make(map[string][]string)
+
+	for _, job := range jobs {
+		if job.Matrix == nil {
+			expanded = append(expanded, job)
+			continue
+		}
+
+		combinations, err := matrixCombinations(job.Name, job.Matrix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range combinations {
+			j := expandJob(job, c)
+			names[job.Name] = append(names[job.Name], j.Name)
+			expanded = append(expanded, j)
+		}
+	}
+
+	for i, job := range expanded {
+		expanded[i].Needs = expandNames(job.Needs, names)
+		expanded[i].Dependencies = expandNames(job.Dependencies, names)
+	}
+
+	return expanded, nil
+}
+
+// expandNames replaces the names of matrix jobs with the names of the jobs created from them.
+// A nil list stays nil.
+func expandNames(list []string, names map[string][]string) []string {
+	if list == nil {
+		return nil
+	}
+	result := make([]string, 0, len(list))
+	for _, v := range list {
+		if expanded, ok := names[v]; ok {
+			result = append(result, expanded...)
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+func matrixCombinations(name string, m *models.Matrix) ([]combination, error) {
+	axes := make(map[string]bool)
+	result := []combination{{}}
+	for _, axis := range m.Axes {
+		if len(axis.Values) == 0 {
+			return nil, fmt.Errorf("%w: matrix variable %s in job %s has no values", ErrInvalidMatrix, axis.Name, name)
+		}
+		axes[axis.Name] = true
+
+		next := make([]combination, 0, len(result)*len(axis.Values))
+		for _, c := range result {
+			for _, v := range axis.Values {
+				n := make(combination, len(c), len(c)+1)
+				copy(n, c)
+				next = append(next, append(n, matrixValue{name: axis.Name, value: v}))
+			}
+		}
+		result = next
+	}
+	if len(m.Axes) == 0 {
+		result = nil
+	}
+
+	for _, exclude := range m.Exclude {
+		for k := range exclude {
+			if !axes[k] {
+				return nil, fmt.Errorf("%w: exclude in job %s refers to unknown matrix variable %s", ErrInvalidMatrix, name, k)
+			}
+		}
+
+		filtered := make([]combination, 0, len(result))
+		for _, c := range result {
+			if !c.matches(exclude) {
+				filtered = append(filtered, c)
+			}
+		}
+		result = filtered
+	}
+
+	for _, include := range m.Include {
+		c := make(combination, 0, len(include))
+		for _, axis := range m.Axes {
+			if v, ok := include[axis.Name]; ok {
+				c = append(c, matrixValue{name: axis.Name, value: v})
+			}
+		}
+		extra := make([]string, 0)
+		for k := range include {
+			if !axes[k] {
+				extra = append(extra, k)
+			}
+		}
+		sort.Strings(extra)
+		for _, k := range extra {
+			c = append(c, matrixValue{name: k, value: include[k]})
+		}
+
+		duplicate := false
+		for _, existing := range result {
+			if len(existing) == len(c) && existing.matches(include) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			result = append(result, c)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: matrix in job %s has no combinations", ErrInvalidMatrix, name)
+	}
+	return result, nil
+}
+
+func expandJob(job models.Job, c combination) models.Job {
+	values := make([]string, 0, len(c))
+	variables := make([]models.Variable, 0, len(job.Variables)+len(c))
+	variables = append(variables, job.Variables...)
+	for _, v := range c {
+		values = append(values, fmt.Sprint(v.value))
+		variables = append(variables, models.Variable{v.name: v.value})
+	}
+
+	job.Name = fmt.Sprintf("%s (%s)", job.Name, strings.Join(values, ", "))
+	job.Variables = variables
+	job.Image = os.Expand(job.Image, func(name string) string {
+		if v, ok := c.get(name); ok {
+			return fmt.Sprint(v)
+		}
+		return "${" + name + "}"
+	})
+	job.Matrix = nil
+	return job
+}
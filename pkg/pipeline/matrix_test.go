@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const matrixJobFile = `
+stages:
+  - test
+  - build
+jobs:
+  - name: Run tests
+    stage: test
+    image: "docker.io/golang:${GO_VERSION}-${OS}"
+    variables:
+      - CGO_ENABLED: 0
+    matrix:
+      GO_VERSION: ["1.21", "1.22"]
+      OS: [alpine, bookworm]
+      exclude:
+        - GO_VERSION: "1.21"
+          OS: bookworm
+      include:
+        - GO_VERSION: "1.23"
+          OS: alpine
+    condition: GO_VERSION != "1.22" || OS == "alpine"
+    artifacts: [coverage.out]
+  - name: Build
+    stage: build
+    image: "docker.io/golang:1.22"
+    needs: ["Run tests"]
+    dependencies: ["Run tests"]
+`
+
+func TestExpandMatrix(t *testing.T) {
+	var jobFile models.JobFile
+	assert.NoError(t, yaml.Unmarshal([]byte(matrixJobFile), &jobFile))
+
+	jobs, err := ExpandMatrix(jobFile.Jobs)
+	assert.NoError(t, err)
+
+	names := make([]string, 0)
+	images := make([]string, 0)
+	for _, job := range jobs {
+		names = append(names, job.Name)
+		images = append(images, job.Image)
+	}
+	assert.Equal(t, []string{
+		"Run tests (1.21, alpine)",
+		"Run tests (1.22, alpine)",
+		"Run tests (1.22, bookworm)",
+		"Run tests (1.23, alpine)",
+		"Build",
+	}, names)
+	assert.Equal(t, []string{
+		"docker.io/golang:1.21-alpine",
+		"docker.io/golang:1.22-alpine",
+		"docker.io/golang:1.22-bookworm",
+		"docker.io/golang:1.23-alpine",
+		"docker.io/golang:1.22",
+	}, images)
+
+	assert.Equal(t, []models.Variable{
+		{"CGO_ENABLED": 0},
+		{"GO_VERSION": "1.22"},
+		{"OS": "bookworm"},
+	}, jobs[2].Variables)
+	assert.Equal(t, names[:4], jobs[4].Needs)
+	assert.Equal(t, names[:4], jobs[4].Dependencies)
+	assert.Nil(t, jobs[0].Dependencies)
+
+	selected := make([]string, 0)
+	for _, job := range jobs {
+		ok, err := Evaluate(job, nil)
+		assert.NoError(t, err)
+		if ok {
+			selected = append(selected, job.Name)
+		}
+	}
+	assert.NotContains(t, selected, "Run tests (1.22, bookworm)")
+	assert.Len(t, selected, 4)
+}
+
+func TestInvalidMatrix(t *testing.T) {
+	tests := []*models.Matrix{
+		{},
+		{Axes: []models.MatrixAxis{{Name: "A"}}},
+		{
+			Axes:    []models.MatrixAxis{{Name: "A", Values: []any{1}}},
+			Exclude: []map[string]any{{"B": 1}},
+		},
+		{
+			Axes:    []models.MatrixAxis{{Name: "A", Values: []any{1}}},
+			Exclude: []map[string]any{{"A": 1}},
+		},
+	}
+
+	for _, m := range tests {
+		_, err := ExpandMatrix([]models.Job{{Name: "job", Stage: "test", Matrix: m}})
+		assert.ErrorIs(t, err, ErrInvalidMatrix)
+	}
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// RunFunc executes a single job.
+type RunFunc func(ctx context.Context, job models.Job) error
+
+type result struct {
+	name string
+	err  error
+}
+
+// exitCoder is implemented by the errors of jobs that exited with a status code.
+type exitCoder interface {
+	ExitCode() int
+}
+
+// AllowedFailure returns true if the job is allowed to fail with err.
+func AllowedFailure(job models.Job, err error) bool {
+	if err == nil || !job.AllowFailure.Enabled {
+		return false
+	}
+	if len(job.AllowFailure.ExitCodes) == 0 {
+		return true
+	}
+
+	var exitErr exitCoder
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	for _, v := range job.AllowFailure.ExitCodes {
+		if v == exitErr.ExitCode() {
+			return true
+		}
+	}
+	return false
+}
+
+// Run executes the jobs in the graph. A job is considered once all of its dependencies are done and
+// runs according to its when rule: on_success jobs run when none of the jobs they depend on, directly or
+// not, failed, on_failure jobs run when one of them failed and always jobs run in any case. Failures that
+// are allowed do not count as failures. Once ctx is done no new jobs are started.
+//
+// The jobs that do not run are recorded in summary, which can be nil. Run waits for the running jobs to
+// finish and returns the first failure that is not allowed, or the error of ctx if jobs were left out
+// because it was done.
+func (g *Graph) Run(ctx context.Context, run RunFunc, summary *Summary) error {
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, job := range g.jobs {
+		pending[job.Name] = len(g.deps[job.Name])
+		for _, dep := range g.deps[job.Name] {
+			dependents[dep] = append(dependents[dep], job.Name)
+		}
+	}
+
+	// failed records the jobs that failed or depend on a job that failed.
+	failed := make(map[string]bool)
+	results := make(chan result)
+	running := 0
+	canceled := false
+	var firstErr error
+
+	var done func(name string)
+	consider := func(job models.Job) {
+		upstreamFailed := false
+		for _, dep := range g.deps[job.Name] {
+			upstreamFailed = upstreamFailed || failed[dep]
+		}
+		failed[job.Name] = upstreamFailed
+
+		if ctx.Err() != nil {
+			canceled = true
+			done(job.Name)
+			return
+		}
+
+		var reason string
+		switch job.When {
+		case models.WhenAlways:
+		case models.WhenOnFailure:
+			if !upstreamFailed {
+				reason = fmt.Sprintf("when is %s and no job it depends on failed", job.When)
+			}
+		default:
+			if upstreamFailed {
+				reason = "a job it depends on failed"
+			}
+		}
+		if len(reason) > 0 {
+			if summary != nil {
+				summary.Set(Result{Job: job.Name, Status: StatusSkipped, Reason: reason})
+			}
+			done(job.Name)
+			return
+		}
+
+		running++
+		go func() {
+			results <- result{name: job.Name, err: run(ctx, job)}
+		}()
+	}
+	done = func(name string) {
+		for _, v := range dependents[name] {
+			pending[v]--
+			if pending[v] == 0 {
+				consider(g.jobs[g.index[v]])
+			}
+		}
+	}
+
+	for _, job := range g.jobs {
+		if pending[job.Name] == 0 {
+			consider(job)
+		}
+	}
+
+	for running > 0 {
+		r := <-results
+		running--
+
+		job := g.jobs[g.index[r.name]]
+		if r.err != nil && (ctx.Err() != nil || !AllowedFailure(job, r.err)) {
+			failed[r.name] = true
+			if firstErr == nil {
+				firstErr = r.err
+			}
+		}
+		done(r.name)
+	}
+
+	if firstErr == nil && canceled {
+		return ctx.Err()
+	}
+	return firstErr
+}
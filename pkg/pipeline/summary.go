@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Status is the outcome of a job in a run.
+type Status string
+
+const (
+	StatusPassed Status = "passed"
+	// StatusPassedOnRetry is a job that failed and passed when it was run again.
+	StatusPassedOnRetry Status = "passed on retry"
+	StatusFailed        Status = "failed"
+	// StatusAllowedFailure is a job that failed without failing the pipeline.
+	StatusAllowedFailure Status = "failed, allowed"
+	StatusTimedOut       Status = "timed out"
+	StatusCanceled       Status = "canceled"
+	StatusSkipped        Status = "skipped"
+	StatusNotStarted     Status = "not started"
+)
+
+// Result is the outcome of a single job.
+type Result struct {
+	Job      string
+	Status   Status
+	Duration time.Duration
+	// Attempts is the number of times the job was run.
+	Attempts int
+	// Reason describes why the job did not pass.
+	Reason string
+}
+
+// Summary collects the results of the jobs in a run. It is safe for concurrent use.
+type Summary struct {
+	lock    sync.Mutex
+	order   []string
+	results map[string]Result
+}
+
+// NewSummary creates a summary where the jobs of the graph have not started and the skipped jobs
+// are reported with their reason.
+func NewSummary(g *Graph, skipped []Skipped) *Summary {
+	s := &Summary{
+		order:   make([]string, 0, len(g.jobs)+len(skipped)),
+		results: make(map[string]Result),
+	}
+	for _, job := range g.jobs {
+		s.order = append(s.order, job.Name)
+		s.results[job.Name] = Result{Job: job.Name, Status: StatusNotStarted}
+	}
+	for _, v := range skipped {
+		s.order = append(s.order, v.Job.Name)
+		s.results[v.Job.Name] = Result{Job: v.Job.Name, Status: StatusSkipped, Reason: v.Reason}
+	}
+	return s
+}
+
+// Set records the result of a job.
+func (s *Summary) Set(r Result) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.results[r.Job]; !ok {
+		s.order = append(s.order, r.Job)
+	}
+	s.results[r.Job] = r
+}
+
+// Results returns the results in the order of the jobs in the graph, followed by the skipped jobs.
+func (s *Summary) Results() []Result {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	results := make([]Result, 0, len(s.order))
+	for _, v := range s.order {
+		results = append(results, s.results[v])
+	}
+	return results
+}
+
+// Status returns the overall status of the run. The run is canceled if a job was canceled, failed if a
+// job failed or timed out and passed otherwise. Allowed failures do not fail the run.
+func (s *Summary) Status() Status {
+	status := StatusPassed
+	for _, r := range s.Results() {
+		switch r.Status {
+		case StatusCanceled:
+			return StatusCanceled
+		case StatusFailed, StatusTimedOut:
+			status = StatusFailed
+		}
+	}
+	return status
+}
+
+// Write prints the results as a table.
+func (s *Summary) Write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSTATUS\tATTEMPTS\tDURATION\tREASON")
+	for _, r := range s.Results() {
+		duration := "-"
+		if r.Duration > 0 {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+		attempts := "-"
+		if r.Attempts > 0 {
+			attempts = fmt.Sprint(r.Attempts)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Job, r.Status, attempts, duration, r.Reason)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{
+		{Name: "unit", Stage: "test"},
+		{Name: "build", Stage: "build"},
+		{Name: "deploy", Stage: "deploy"},
+	})
+	assert.NoError(t, err)
+
+	s := NewSummary(g, []Skipped{{Job: models.Job{Name: "lint"}, Reason: "not selected by --job"}})
+	s.Set(Result{Job: "unit", Status: StatusPassedOnRetry, Attempts: 2, Duration: 1500 * time.Millisecond})
+	s.Set(Result{Job: "build", Status: StatusTimedOut, Attempts: 1, Duration: time.Minute, Reason: "timed out after 1m0s"})
+
+	var b bytes.Buffer
+	assert.NoError(t, s.Write(&b))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 5)
+	assert.Equal(t, []string{"unit", "passed", "on", "retry", "2", "1.5s"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"build", "timed", "out", "1", "1m0s", "timed", "out", "after", "1m0s"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"deploy", "not", "started", "-", "-"}, strings.Fields(lines[3]))
+	assert.Equal(t, []string{"lint", "skipped", "-", "-", "not", "selected", "by", "--job"}, strings.Fields(lines[4]))
+}
+
+func TestSummaryStatus(t *testing.T) {
+	g, err := NewGraph(testStages, []models.Job{{Name: "lint", Stage: "test"}, {Name: "unit", Stage: "test"}})
+	assert.NoError(t, err)
+
+	s := NewSummary(g, nil)
+	s.Set(Result{Job: "lint", Status: StatusAllowedFailure})
+	s.Set(Result{Job: "unit", Status: StatusPassed})
+	assert.Equal(t, StatusPassed, s.Status())
+
+	s.Set(Result{Job: "unit", Status: StatusTimedOut})
+	assert.Equal(t, StatusFailed, s.Status())
+
+	s.Set(Result{Job: "lint", Status: StatusCanceled})
+	assert.Equal(t, StatusCanceled, s.Status())
+}
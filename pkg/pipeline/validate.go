@@ -0,0 +1,539 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/opnlabs/dot/pkg/cache"
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	validate       = validator.New(validator.WithRequiredStructEnabled())
+	yamlLineRegexp = regexp.MustCompile(`line (\d+): (.*)`)
+	namespaceRegex = regexp.MustCompile(`^(\w+)(?:\[(\d+)\])?$`)
+	jobIndexRegex  = regexp.MustCompile(`\.jobs\[(\d+)\]\.`)
+)
+
+func init() {
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// Problem is an error in a job file with the position where it was found.
+// Line and Column are 0 when the position is not known.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// Problems is the list of problems found in a job file.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	lines := make([]string, 0, len(p))
+	for _, v := range p {
+		lines = append(lines, v.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type checker struct {
+	file     string
+	env      []models.Variable
+	root     *yaml.Node
+	problems Problems
+}
+
+// Parse decodes and validates the contents of a job file. Instead of stopping at the first problem,
+// every problem found is returned as Problems, so the returned error can be printed directly.
+// The env variables are available to the job conditions, like in Evaluate.
+func Parse(file string, contents []byte, env []models.Variable) (models.JobFile, error) {
+	c := &checker{file: file, env: env}
+	var jobFile models.JobFile
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		c.addYAMLError(err)
+		return jobFile, c.problems
+	}
+	if len(doc.Content) == 0 {
+		c.problems = append(c.problems, Problem{File: file, Message: "job file is empty"})
+		return jobFile, c.problems
+	}
+	c.root = doc.Content[0]
+
+	if err := c.root.Decode(&jobFile); err != nil {
+		c.addYAMLError(err)
+		return jobFile, c.problems
+	}
+
+	c.checkKnownFields()
+	c.checkStruct(jobFile)
+	c.checkStages(jobFile)
+	c.checkJobs(jobFile)
+
+	if len(c.problems) > 0 {
+		sort.SliceStable(c.problems, func(i, j int) bool {
+			if c.problems[i].Line != c.problems[j].Line {
+				return c.problems[i].Line < c.problems[j].Line
+			}
+			return c.problems[i].Column < c.problems[j].Column
+		})
+		return jobFile, c.problems
+	}
+	return jobFile, nil
+}
+
+func (c *checker) add(n *yaml.Node, format string, a ...any) {
+	p := Problem{File: c.file, Message: fmt.Sprintf(format, a...)}
+	if n != nil {
+		p.Line, p.Column = n.Line, n.Column
+	}
+	c.problems = append(c.problems, p)
+}
+
+// addYAMLError converts the errors returned by the yaml package, which only contain the line number.
+func (c *checker) addYAMLError(err error) {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+
+	for _, v := range messages {
+		match := yamlLineRegexp.FindStringSubmatch(v)
+		if match == nil {
+			c.problems = append(c.problems, Problem{File: c.file, Message: strings.TrimPrefix(v, "yaml: ")})
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		c.problems = append(c.problems, Problem{File: c.file, Line: line, Column: c.column(line), Message: match[2]})
+	}
+}
+
+// column returns the column of the last node that starts on the line, which is usually the value
+// the error refers to. It returns 0 if there is none.
+func (c *checker) column(line int) int {
+	column := 0
+	var find func(n *yaml.Node)
+	find = func(n *yaml.Node) {
+		if n == nil {
+			return
+		}
+		if n.Line == line && n.Column > column {
+			column = n.Column
+		}
+		for _, v := range n.Content {
+			find(v)
+		}
+	}
+	find(c.root)
+	return column
+}
+
+// checkKnownFields reports keys that do not correspond to any field, which are usually typos.
+func (c *checker) checkKnownFields() {
+	c.checkMappingKeys(c.root, reflect.TypeOf(models.JobFile{}))
+}
+
+func (c *checker) checkMappingKeys(n *yaml.Node, t reflect.Type) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return
+	}
+
+	known := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]] = t.Field(i).Type
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		field, ok := known[n.Content[i].Value]
+		if !ok {
+			c.add(n.Content[i], "unknown field %s", n.Content[i].Value)
+			continue
+		}
+
+		// Nested structs, and lists of them, are checked too, unless they decode themselves.
+		value := n.Content[i+1]
+		if field.Kind() == reflect.Slice && value.Kind == yaml.SequenceNode {
+			field = field.Elem()
+			for _, v := range value.Content {
+				c.checkStructKeys(v, field)
+			}
+			continue
+		}
+		c.checkStructKeys(value, field)
+	}
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+func (c *checker) checkStructKeys(n *yaml.Node, t reflect.Type) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(unmarshalerType) {
+		c.checkMappingKeys(n, t)
+	}
+}
+
+func (c *checker) checkStruct(jobFile models.JobFile) {
+	err := validate.Struct(jobFile)
+	if err == nil {
+		return
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		c.add(c.root, "%v", err)
+		return
+	}
+
+	for _, v := range validationErrors {
+		n := c.lookup(v.Namespace())
+		message := describe(v)
+		if prefix := c.jobPrefix(v.Namespace(), jobFile); len(prefix) > 0 {
+			message = prefix + message
+		}
+		c.add(n, "%s", message)
+	}
+}
+
+// describe converts the validation tag into a readable message.
+func describe(v validator.FieldError) string {
+	switch v.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", v.Field())
+	case "gte":
+		if v.Param() == "0" {
+			return fmt.Sprintf("%s should not be negative", v.Field())
+		}
+		return fmt.Sprintf("%s should be at least %s", v.Field(), v.Param())
+	case "min":
+		if v.Param() == "1" {
+			return fmt.Sprintf("%s should not be empty", v.Field())
+		}
+		return fmt.Sprintf("%s should have at least %s items", v.Field(), v.Param())
+	case "oneof":
+		return fmt.Sprintf("%s should be one of %s", v.Field(), strings.Join(strings.Fields(v.Param()), ", "))
+	case "required_unless":
+		param := strings.Fields(v.Param())
+		if len(param) == 2 {
+			return fmt.Sprintf("%s is required unless %s is %s", v.Field(), strings.ToLower(param[0]), param[1])
+		}
+	}
+	return fmt.Sprintf("%s failed the %s validation", v.Field(), v.Tag())
+}
+
+// lookup finds the node for a validator namespace like JobFile.jobs[2].image.
+// If the node does not exist, the closest parent node is returned.
+func (c *checker) lookup(namespace string) *yaml.Node {
+	n := c.root
+	segments := strings.Split(namespace, ".")
+	for _, v := range segments[1:] {
+		match := namespaceRegex.FindStringSubmatch(v)
+		if match == nil {
+			return n
+		}
+
+		_, value := mappingValue(n, match[1])
+		if value == nil {
+			return n
+		}
+		n = value
+
+		if len(match[2]) > 0 {
+			i, _ := strconv.Atoi(match[2])
+			if n.Kind != yaml.SequenceNode || i >= len(n.Content) {
+				return n
+			}
+			n = n.Content[i]
+		}
+	}
+	return n
+}
+
+func (c *checker) jobPrefix(namespace string, jobFile models.JobFile) string {
+	match := jobIndexRegex.FindStringSubmatch(namespace)
+	if match == nil {
+		return ""
+	}
+	i, _ := strconv.Atoi(match[1])
+	if i < len(jobFile.Jobs) && len(jobFile.Jobs[i].Name) > 0 {
+		return fmt.Sprintf("job %q: ", jobFile.Jobs[i].Name)
+	}
+	return fmt.Sprintf("job %d: ", i+1)
+}
+
+func (c *checker) checkStages(jobFile models.JobFile) {
+	_, stagesNode := mappingValue(c.root, "stages")
+	seen := make(map[models.Stage]bool)
+	for i, v := range jobFile.Stages {
+		if seen[v] {
+			c.add(stagesNode.Content[i], "stage %s is defined more than once", v)
+		}
+		seen[v] = true
+	}
+}
+
+func (c *checker) checkJobs(jobFile models.JobFile) {
+	jobNodes := c.jobNodes()
+	if len(jobNodes) != len(jobFile.Jobs) {
+		return
+	}
+	stages := make(map[models.Stage]bool)
+	for _, v := range jobFile.Stages {
+		stages[v] = true
+	}
+
+	artifactNames := make(map[string]bool)
+	for _, job := range jobFile.Jobs {
+		for _, v := range job.Artifacts {
+			if len(v.Name) > 0 {
+				artifactNames[v.Name] = true
+			}
+		}
+	}
+
+	names := make(map[string]*yaml.Node)
+	jobIndex := make(map[string]int)
+	for i, job := range jobFile.Jobs {
+		if len(job.Name) == 0 {
+			continue
+		}
+		n := nameNode(jobNodes[i])
+		if first, ok := names[job.Name]; ok {
+			c.add(n, "job name %q is already used on line %d", job.Name, first.Line)
+			continue
+		}
+		names[job.Name] = n
+		jobIndex[job.Name] = i
+	}
+
+	// Jobs are expanded one at a time so matrix problems point to the job that caused them.
+	// Only the jobs without stage, name or matrix problems are checked for cycles.
+	source := make(map[string]int)
+	graphJobs := make([]models.Job, 0, len(jobFile.Jobs))
+	for i, job := range jobFile.Jobs {
+		n := jobNodes[i]
+		prefix := fmt.Sprintf("job %q: ", job.Name)
+
+		validStage := stages[job.Stage]
+		if len(job.Stage) > 0 && !validStage {
+			_, stageNode := mappingValue(n, "stage")
+			c.add(stageNode, "%sstage %s is not defined in stages", prefix, job.Stage)
+		}
+
+		validVariables := true
+		_, variablesNode := mappingValue(n, "variables")
+		for j, v := range job.Variables {
+			if len(v) != 1 {
+				validVariables = false
+				c.add(variablesNode.Content[j], "%svariables should be defined as a key value pair, found %d keys", prefix, len(v))
+			}
+		}
+
+		_, servicesNode := mappingValue(n, "services")
+		if len(job.Services) > 0 && job.Runner == "shell" {
+			c.add(servicesNode, "%sservices are not supported by the shell runner", prefix)
+		}
+		aliases := make(map[string]bool)
+		for j, v := range job.Services {
+			if len(v.Image) > 0 && aliases[v.Hostname()] {
+				c.add(servicesNode.Content[j], "%sservice alias %s is used more than once", prefix, v.Hostname())
+			}
+			aliases[v.Hostname()] = true
+		}
+
+		_, artifactsNode := mappingValue(n, "artifacts")
+		if artifactsNode != nil && len(artifactsNode.Content) == len(job.Artifacts) {
+			for j, v := range job.Artifacts {
+				for _, pattern := range append([]string{v.Path}, v.Exclude...) {
+					if err := utils.ValidateGlob(pattern); err != nil {
+						c.add(artifactsNode.Content[j], "%sartifact pattern %s is invalid: %v", prefix, pattern, err)
+					}
+				}
+			}
+		}
+
+		if job.Cache != nil {
+			_, cacheNode := mappingValue(n, "cache")
+			if job.Runner == "shell" {
+				c.add(cacheNode, "%scache is not supported by the shell runner", prefix)
+			}
+			_, keyNode := mappingValue(cacheNode, "key")
+			if err := cache.ValidateKey(job.Cache.Key); err != nil {
+				c.add(keyNode, "%s%v", prefix, err)
+			}
+			_, fallbackNode := mappingValue(cacheNode, "fallback_keys")
+			for j, v := range job.Cache.FallbackKeys {
+				if err := cache.ValidateKey(v); err != nil {
+					c.add(fallbackNode.Content[j], "%s%v", prefix, err)
+				}
+			}
+		}
+
+		_, needsNode := mappingValue(n, "needs")
+		for j, need := range job.Needs {
+			if _, ok := names[need]; !ok {
+				c.add(needsNode.Content[j], "%sneeds unknown job %s", prefix, need)
+			}
+		}
+
+		_, dependenciesNode := mappingValue(n, "dependencies")
+		for j, dependency := range job.Dependencies {
+			if producer, ok := jobIndex[dependency]; ok {
+				if len(jobFile.Jobs[producer].Artifacts) == 0 {
+					c.add(dependenciesNode.Content[j], "%sdependency %s does not publish artifacts", prefix, dependency)
+				}
+				continue
+			}
+			if !artifactNames[dependency] {
+				c.add(dependenciesNode.Content[j], "%sdependencies unknown job or artifact %s", prefix, dependency)
+			}
+		}
+
+		jobs, err := ExpandMatrix([]models.Job{job})
+		if err != nil {
+			_, matrixNode := mappingValue(n, "matrix")
+			c.add(matrixNode, "%s%v", prefix, err)
+			continue
+		}
+
+		for _, v := range jobs {
+			if !validVariables {
+				break
+			}
+			if _, _, err := compileCondition(v, c.env); err != nil {
+				_, conditionNode := mappingValue(n, "condition")
+				if conditionNode == nil {
+					conditionNode = n
+				}
+				// expr adds the expression and a marker on the following lines
+				c.add(conditionNode, "%s", strings.SplitN(err.Error(), "\n", 2)[0])
+				break
+			}
+		}
+
+		if validStage && names[job.Name] == nameNode(n) {
+			// The jobs created from a matrix can take the name of another job.
+			collision := false
+			for _, v := range jobs {
+				if j, ok := source[v.Name]; ok {
+					c.add(nameNode(n), "%sjob name %q is already used on line %d", prefix, v.Name, nameNode(jobNodes[j]).Line)
+					collision = true
+					break
+				}
+			}
+			if collision {
+				continue
+			}
+			graphJobs = append(graphJobs, job)
+			for _, v := range jobs {
+				source[v.Name] = i
+			}
+		}
+	}
+
+	// Needs on matrix jobs are resolved against all the jobs, like ExpandMatrix does in a run.
+	_, jobsNode := mappingValue(c.root, "jobs")
+	expanded, err := ExpandMatrix(graphJobs)
+	if err != nil {
+		c.add(jobsNode, "%v", err)
+		return
+	}
+	g, err := newGraph(jobFile.Stages, expanded)
+	if err != nil {
+		c.add(jobsNode, "%v", err)
+		return
+	}
+	if cycle := g.findCycle(); len(cycle) > 0 {
+		n := jobNodes[source[cycle[0]]]
+		if _, needsNode := mappingValue(n, "needs"); needsNode != nil {
+			n = needsNode
+		}
+		c.add(n, "%v: %s", ErrCycle, strings.Join(cycle, " -> "))
+		return
+	}
+	c.checkDependencies(jobFile, g, source)
+}
+
+// checkDependencies reports the dependencies published by jobs that do not finish before the job starts.
+// source maps the jobs in the graph to the index of the job they were expanded from.
+func (c *checker) checkDependencies(jobFile models.JobFile, g *Graph, source map[string]int) {
+	jobNodes := c.jobNodes()
+	reported := make(map[*yaml.Node]bool)
+	for _, job := range g.Jobs() {
+		i := source[job.Name]
+		raw := jobFile.Jobs[i]
+		_, dependenciesNode := mappingValue(jobNodes[i], "dependencies")
+		upstream := make(map[string]bool)
+		for _, v := range g.upstream(job.Name) {
+			upstream[v] = true
+		}
+
+		for j, dependency := range raw.Dependencies {
+			for _, producer := range g.Jobs() {
+				if len(producer.Artifacts) == 0 {
+					continue
+				}
+				if producer.Name != dependency && jobFile.Jobs[source[producer.Name]].Name != dependency && !producer.Publishes(dependency) {
+					continue
+				}
+				if !upstream[producer.Name] && !reported[dependenciesNode.Content[j]] {
+					reported[dependenciesNode.Content[j]] = true
+					c.add(dependenciesNode.Content[j], "job %q: dependency %s does not run before the job, add it to needs or move it to an earlier stage", raw.Name, dependency)
+					break
+				}
+			}
+		}
+	}
+}
+
+func nameNode(job *yaml.Node) *yaml.Node {
+	_, n := mappingValue(job, "name")
+	return n
+}
+
+func (c *checker) jobNodes() []*yaml.Node {
+	_, jobs := mappingValue(c.root, "jobs")
+	if jobs == nil || jobs.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return jobs.Content
+}
+
+// mappingValue returns the key and value nodes for key in the mapping node n.
+func mappingValue(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
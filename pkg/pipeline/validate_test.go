@@ -0,0 +1,273 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const invalidJobFile = `stages:
+  - test
+  - build
+
+jobs:
+  - name: Run tests
+    stage: test
+    image: "docker.io/golang:1.21.3"
+    variables:
+      - TEST: true
+        OTHER: 1
+    scirpt:
+      - go test ./...
+
+  - name: Run tests
+    stage: release
+    needs: [missing]
+
+  - name: a
+    stage: test
+    image: alpine
+    condition: UNDEFINED == 1
+    needs: [b]
+
+  - name: b
+    stage: build
+    image: alpine
+    needs: [a]
+    timeout: -1m
+    retry:
+      attempts: 0
+      on: [network]
+      delay: 1s
+    when: later
+`
+
+func TestParseProblems(t *testing.T) {
+	_, err := Parse("dot.yml", []byte(invalidJobFile), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+
+	messages := make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:10:9: job "Run tests": variables should be defined as a key value pair, found 2 keys`,
+		`dot.yml:12:5: unknown field scirpt`,
+		`dot.yml:15:5: job "Run tests": image is required unless runner is shell`,
+		`dot.yml:15:11: job name "Run tests" is already used on line 6`,
+		`dot.yml:16:12: job "Run tests": stage release is not defined in stages`,
+		`dot.yml:17:13: job "Run tests": needs unknown job missing`,
+		`dot.yml:22:16: condition evaluation failed for job a: unknown name UNDEFINED (1:1)`,
+		`dot.yml:23:12: pipeline: dependency cycle: a -> b -> a`,
+		`dot.yml:29:14: job "b": timeout should not be negative`,
+		`dot.yml:31:17: job "b": attempts should be at least 1`,
+		`dot.yml:32:12: job "b": on[0] should be one of image_pull, exit_code, timeout`,
+		`dot.yml:33:7: unknown field delay`,
+		`dot.yml:34:11: job "b": when should be one of on_success, on_failure, always, manual`,
+	}, messages)
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := Parse("dot.yml", []byte("stages:\n  - test\njobs:\n  - name: [\n"), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+	assert.Len(t, problems, 1)
+	assert.Equal(t, 4, problems[0].Line)
+}
+
+func TestParseTypeError(t *testing.T) {
+	_, err := Parse("dot.yml", []byte("stages:\n  - test\njobs:\n  - name: test\n    stage: test\n    image: alpine\n    script: echo\n"), nil)
+	assert.EqualError(t, err, "dot.yml:7:13: cannot unmarshal !!str `echo` into []string")
+}
+
+func TestParseValid(t *testing.T) {
+	jobFile, err := Parse("dot.yml", []byte(matrixJobFile), nil)
+	assert.NoError(t, err)
+	assert.Len(t, jobFile.Jobs, 2)
+}
+
+func TestParseServices(t *testing.T) {
+	_, err := Parse("dot.yml", []byte(`stages: [test]
+jobs:
+  - name: integration
+    stage: test
+    runner: shell
+    services:
+      - image: postgres
+      - image: docker.io/library/postgres:16
+        healthcheck:
+          test: [pg_isready]
+          every: 1s
+      - alias: cache
+`), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+
+	messages := make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:7:7: job "integration": services are not supported by the shell runner`,
+		`dot.yml:8:9: job "integration": service alias postgres is used more than once`,
+		`dot.yml:11:11: unknown field every`,
+		`dot.yml:12:9: job "integration": image is required`,
+	}, messages)
+}
+
+func TestParseCache(t *testing.T) {
+	_, err := Parse("dot.yml", []byte(`stages: [build]
+jobs:
+  - name: build
+    stage: build
+    image: golang
+    cache:
+      key: go-{{ hashFiles "go.sum"
+      fallback_keys: [go-]
+      paths: []
+`), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+
+	messages := make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:7:12: job "build": invalid cache key go-{{ hashFiles "go.sum": template: key:1: unclosed action`,
+		`dot.yml:9:14: job "build": paths should not be empty`,
+	}, messages)
+
+	_, err = Parse("dot.yml", []byte(`stages: [build]
+jobs:
+  - name: build
+    stage: build
+    runner: shell
+    cache:
+      key: go
+      paths: [.cache]
+`), nil)
+	assert.EqualError(t, err, `dot.yml:7:7: job "build": cache is not supported by the shell runner`)
+}
+
+func TestParseArtifacts(t *testing.T) {
+	_, err := Parse("dot.yml", []byte(`stages: [build]
+jobs:
+  - name: build
+    stage: build
+    image: golang
+    artifacts:
+      - dist/[a-
+      - path: coverage/**/*.out
+        exclude: [coverage/tmp, "coverage/[x"]
+        optional: true
+`), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+
+	messages := make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:7:9: job "build": artifact pattern dist/[a- is invalid: syntax error in pattern`,
+		`dot.yml:8:9: job "build": artifact pattern coverage/[x is invalid: syntax error in pattern`,
+	}, messages)
+}
+
+func TestParseMatrixNameCollision(t *testing.T) {
+	_, err := Parse("dot.yml", []byte(`stages: [test]
+jobs:
+  - name: test (1.21)
+    stage: test
+    image: golang
+  - name: test
+    stage: test
+    image: golang
+    matrix:
+      GO_VERSION: ["1.21", "1.22"]
+`), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+
+	messages := make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:6:11: job "test": job name "test (1.21)" is already used on line 3`,
+	}, messages)
+}
+
+func TestParseDependencies(t *testing.T) {
+	_, err := Parse("dot.yml", []byte(`stages: [build, test]
+jobs:
+  - name: lint
+    stage: build
+    image: golang
+  - name: unit
+    stage: test
+    image: golang
+    artifacts: [coverage.out]
+  - name: build
+    stage: build
+    image: golang
+    artifacts:
+      - name: binary
+        path: bin
+      - name: docs
+        file: public
+    dependencies: [unit, lint, binary, missing]
+  - name: report
+    stage: test
+    image: golang
+    dependencies: [unit, binary]
+`), nil)
+	problems, ok := err.(Problems)
+	assert.True(t, ok)
+
+	messages := make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:17:15: unknown field file in artifacts`,
+	}, messages)
+
+	_, err = Parse("dot.yml", []byte(`stages: [build, test]
+jobs:
+  - name: lint
+    stage: build
+    image: golang
+  - name: unit
+    stage: test
+    image: golang
+    artifacts: [coverage.out]
+  - name: build
+    stage: build
+    image: golang
+    artifacts:
+      - name: binary
+        path: bin
+    dependencies: [unit, lint, binary, missing]
+  - name: report
+    stage: test
+    image: golang
+    dependencies: [unit, binary]
+`), nil)
+	problems, ok = err.(Problems)
+	assert.True(t, ok)
+
+	messages = make([]string, 0)
+	for _, v := range problems {
+		messages = append(messages, v.String())
+	}
+	assert.Equal(t, []string{
+		`dot.yml:16:20: job "build": dependency unit does not run before the job, add it to needs or move it to an earlier stage`,
+		`dot.yml:16:26: job "build": dependency lint does not publish artifacts`,
+		`dot.yml:16:32: job "build": dependency binary does not run before the job, add it to needs or move it to an earlier stage`,
+		`dot.yml:16:40: job "build": dependencies unknown job or artifact missing`,
+		`dot.yml:20:20: job "report": dependency unit does not run before the job, add it to needs or move it to an earlier stage`,
+	}, messages)
+}
@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/opnlabs/dot/pkg/cache"
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// WithCache specifies the paths that are restored before the job starts and saved after it succeeds.
+// The cache is stored in the Cache of the DockerRunnerOptions.
+func (d *DockerRunner) WithCache(c *models.Cache) *DockerRunner {
+	d.cache = c
+	return d
+}
+
+// cacheKeys renders the key and the fallback keys of the cache.
+func (d *DockerRunner) cacheKeys() (string, []string, error) {
+	env := make(map[string]string)
+	for _, v := range d.env {
+		if k, value, ok := strings.Cut(v, "="); ok {
+			env[k] = value
+		}
+	}
+
+	key, err := cache.RenderKey(d.cache.Key, d.src, env)
+	if err != nil {
+		return "", nil, err
+	}
+	fallbackKeys := make([]string, 0, len(d.cache.FallbackKeys))
+	for _, v := range d.cache.FallbackKeys {
+		fallbackKey, err := cache.RenderKey(v, d.src, env)
+		if err != nil {
+			return "", nil, err
+		}
+		fallbackKeys = append(fallbackKeys, fallbackKey)
+	}
+	return key, fallbackKeys, nil
+}
+
+// restoreCache copies the cached paths into the container. It returns true if the cache was stored
+// under key, in which case it does not need to be saved again. Problems with the cache are reported
+// but do not fail the job.
+func (d *DockerRunner) restoreCache(ctx context.Context, cli *client.Client, key string, fallbackKeys []string) bool {
+	entry, err := d.dockerOptions.Cache.Find(key, fallbackKeys)
+	if errors.Is(err, cache.ErrNotFound) {
+		fmt.Fprintf(d.dockerOptions.Stdout, "no cache found for key %s\n", key)
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(d.dockerOptions.Stderr, "unable to read cache: %v\n", err)
+		return false
+	}
+
+	for _, v := range entry.Paths {
+		r, err := d.dockerOptions.Cache.Open(entry, v)
+		if err != nil {
+			fmt.Fprintf(d.dockerOptions.Stderr, "unable to restore cache of %s: %v\n", v, err)
+			return false
+		}
+		err = cli.CopyToContainer(ctx, d.containerID, "/", r, types.CopyToContainerOptions{})
+		r.Close()
+		if err != nil {
+			fmt.Fprintf(d.dockerOptions.Stderr, "unable to restore cache of %s: %v\n", v, err)
+			return false
+		}
+	}
+	fmt.Fprintf(d.dockerOptions.Stdout, "restored cache %s\n", entry.Key)
+	return entry.Key == key
+}
+
+// saveCache stores the cached paths of the container under key. Paths that do not exist are skipped.
+func (d *DockerRunner) saveCache(ctx context.Context, cli *client.Client, key string) {
+	paths := make([]string, 0, len(d.cache.Paths))
+	for _, v := range d.cache.Paths {
+		p := cachePath(v)
+		if _, err := cli.ContainerStatPath(ctx, d.containerID, p); err != nil {
+			fmt.Fprintf(d.dockerOptions.Stderr, "not caching %s: %v\n", p, err)
+			continue
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	entry, err := d.dockerOptions.Cache.Save(key, paths, func(p string) (io.ReadCloser, error) {
+		r, _, err := cli.CopyFromContainer(ctx, d.containerID, p)
+		if err != nil {
+			return nil, err
+		}
+		return rerootTar(r, path.Dir(p)), nil
+	})
+	if err != nil {
+		fmt.Fprintf(d.dockerOptions.Stderr, "unable to save cache %s: %v\n", key, err)
+		return
+	}
+	fmt.Fprintf(d.dockerOptions.Stdout, "saved cache %s (%d bytes)\n", entry.Key, entry.Size)
+}
+
+func cachePath(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(WORKING_DIR, p)
+}
+
+// rerootTar moves the entries of the archive under dir, so the archive can be extracted at / in a container
+// where dir does not exist. The engine creates the missing parent directories.
+func rerootTar(r io.ReadCloser, dir string) io.ReadCloser {
+	prefix := strings.TrimPrefix(path.Clean(dir), "/")
+	pr, pw := io.Pipe()
+	go func() {
+		defer r.Close()
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			h.Name = path.Join(prefix, h.Name)
+			if h.Typeflag == tar.TypeDir {
+				h.Name += "/"
+			}
+			if h.Typeflag == tar.TypeLink {
+				h.Linkname = path.Join(prefix, h.Linkname)
+			}
+			if err := tw.WriteHeader(h); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
@@ -1,27 +1,29 @@
-// Package runner implements the backend that executes the jobs.
-//
-// Docker runner uses the docker runtime to execute jobs.
 package runner
 
 import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/gosimple/slug"
 	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/cache"
+	"github.com/opnlabs/dot/pkg/engine"
 	"github.com/opnlabs/dot/pkg/models"
 	"github.com/opnlabs/dot/pkg/utils"
 	"github.com/rs/xid"
@@ -30,6 +32,8 @@ import (
 const (
 	ARTIFACTS_DIR = ".artifacts"
 	WORKING_DIR   = "/app"
+	// CONTAINER_LABEL is set on every container created by dot. The value is the name of the job.
+	CONTAINER_LABEL = engine.Label
 )
 
 type DockerRunnerOptions struct {
@@ -37,10 +41,20 @@ type DockerRunnerOptions struct {
 	Stdout            io.Writer
 	Stderr            io.Writer
 	MountDockerSocket bool
+	// Engine selects the container engine. Docker is used if it is empty.
+	Engine engine.Engine
+	// StopTimeout is the time the container is given to exit after SIGTERM when the job is stopped,
+	// before it is killed.
+	StopTimeout time.Duration
+	// Cache stores the caches of the jobs. Jobs are run without their cache if it is nil.
+	Cache *cache.Cache
+	// Events receives the lifecycle events of the job. It can be nil.
+	Events Events
 }
 
 type DockerRunner struct {
 	name             string
+	jobName          string
 	image            string
 	src              string
 	env              []string
@@ -48,10 +62,14 @@ type DockerRunner struct {
 	entrypoint       []string
 	containerID      string
 	workingDirectory string
-	artifacts        []string
+	artifacts        []models.Artifact
+	dependencies     []string
 	artifactManager  artifacts.ArtifactManager
 	dockerOptions    DockerRunnerOptions
 	authConfig       string
+	services         []*service
+	networkID        string
+	cache            *models.Cache
 }
 
 func NewDockerRunner(name string, artifactManager artifacts.ArtifactManager, dockerOptions DockerRunnerOptions) *DockerRunner {
@@ -70,6 +88,7 @@ func NewDockerRunner(name string, artifactManager artifacts.ArtifactManager, doc
 
 	return &DockerRunner{
 		name:             jobName,
+		jobName:          name,
 		src:              filepath.Clean(""),
 		workingDirectory: wd,
 		artifactManager:  artifactManager,
@@ -77,6 +96,29 @@ func NewDockerRunner(name string, artifactManager artifacts.ArtifactManager, doc
 	}
 }
 
+// NewDockerFactory returns a Factory that creates a DockerRunner for each job.
+// The username and password are used to authenticate with the image registry.
+func NewDockerFactory(dockerOptions DockerRunnerOptions, username, password string) Factory {
+	return func(job models.Job, opts Options) Runner {
+		options := dockerOptions
+		options.Stdout = opts.Stdout
+		options.Stderr = opts.Stderr
+		options.Events = opts.Events
+
+		return NewDockerRunner(job.Name, opts.ArtifactManager, options).
+			WithImage(job.Image).
+			WithSrc(job.Src).
+			WithCmd(job.Script).
+			WithEntrypoint(job.Entrypoint).
+			WithEnv(append(job.Variables, opts.Env...)).
+			WithCredentials(username, password).
+			WithServices(job.Services).
+			WithCache(job.Cache).
+			CreatesArtifacts(job.Artifacts).
+			WithDependencies(job.Dependencies)
+	}
+}
+
 // WithImage takes the image url as input and returns a Docker runner.
 // The image url format is the same one used in docker pull <url>.
 func (d *DockerRunner) WithImage(image string) *DockerRunner {
@@ -94,16 +136,7 @@ func (d *DockerRunner) WithSrc(src string) *DockerRunner {
 // WithEnv is used to specify job variables.
 // Env is an array of map[string]any. The length of the map should be 1.
 func (d *DockerRunner) WithEnv(env []models.Variable) *DockerRunner {
-	variables := make([]string, 0)
-	for _, v := range env {
-		if len(v) > 1 {
-			log.Fatal("variables should be defined as a key value pair")
-		}
-		for k, v := range v {
-			variables = append(variables, fmt.Sprintf("%s=%s", k, fmt.Sprint(v)))
-		}
-	}
-	d.env = variables
+	d.env = formatEnv(env)
 	return d
 }
 
@@ -135,22 +168,57 @@ func (d *DockerRunner) WithCredentials(username, password string) *DockerRunner
 }
 
 // CreatesArtifacts is used to specify the files that will be stored as artifacts.
-// The input is a list of file paths wrt the src specified in WithSrc.
-func (d *DockerRunner) CreatesArtifacts(artifacts []string) *DockerRunner {
+// The paths are relative to the src specified in WithSrc. Artifacts without a name are published
+// under the name of the job.
+func (d *DockerRunner) CreatesArtifacts(artifacts []models.Artifact) *DockerRunner {
 	d.artifacts = artifacts
 	return d
 }
 
+// WithDependencies specifies the jobs or artifact names whose artifacts are copied into the job.
+// All the artifacts are copied if dependencies is nil, and none if it is empty.
+func (d *DockerRunner) WithDependencies(dependencies []string) *DockerRunner {
+	d.dependencies = dependencies
+	return d
+}
+
 // Run creates the container based on the provided configuration.
-func (d *DockerRunner) Run(ctx context.Context) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// When ctx is done the container is stopped, giving it StopTimeout to exit, and removed.
+func (d *DockerRunner) Run(ctx context.Context) (err error) {
+	cli, err := engine.NewClient(d.dockerOptions.Engine)
 	if err != nil {
 		return fmt.Errorf("unable to create docker client to create container %s: %v", d.name, err)
 	}
 	defer cli.Close()
 
-	if err := d.pullImage(ctx, cli); err != nil {
-		return fmt.Errorf("could not pull image for container %s: %v", d.name, err)
+	// Calls to the engine fail with unrelated errors once ctx is done, so the reason is reported instead.
+	defer func() {
+		if err != nil && ctx.Err() != nil && !errors.Is(err, ErrCanceled) && !errors.Is(err, ErrTimeout) {
+			err = fmt.Errorf("%w, stopping container %s", stopReason(ctx), d.name)
+		}
+	}()
+
+	if err := d.pull(ctx, cli, d.image); err != nil {
+		return fmt.Errorf("%w for container %s: %v", ErrImagePull, d.name, err)
+	}
+
+	if len(d.services) > 0 {
+		if err := d.createNetwork(ctx, cli); err != nil {
+			return fmt.Errorf("unable to create network for %s: %v", d.name, err)
+		}
+		// Registered first so the services and the network are removed after the job container.
+		defer func() {
+			if err != nil {
+				d.writeServiceLogs(cli, d.dockerOptions.Stderr)
+			}
+			if rErr := d.removeServices(cli); rErr != nil && err == nil {
+				err = fmt.Errorf("unable to remove services of %s: %v", d.name, rErr)
+			}
+		}()
+
+		if err := d.startServices(ctx, cli); err != nil {
+			return fmt.Errorf("unable to start services for %s: %v", d.name, err)
+		}
 	}
 
 	resp, err := d.createContainer(ctx, cli)
@@ -159,8 +227,8 @@ func (d *DockerRunner) Run(ctx context.Context) error {
 		return fmt.Errorf("unable to create container %s: %v", d.name, err)
 	}
 	defer func() {
-		if rErr := cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{}); rErr != nil {
-			err = rErr
+		if rErr := d.removeContainer(cli, ctx.Err() != nil); rErr != nil && err == nil {
+			err = fmt.Errorf("unable to remove container %s: %v", d.name, rErr)
 		}
 	}()
 
@@ -168,10 +236,22 @@ func (d *DockerRunner) Run(ctx context.Context) error {
 		return fmt.Errorf("unable to create source directories for %s: %v", d.name, err)
 	}
 
-	if err := d.artifactManager.RetrieveArtifact(d.containerID, nil); err != nil {
+	if err := d.artifactManager.RetrieveArtifact(d.containerID, d.dependencies); err != nil {
 		return fmt.Errorf("unable to retrieve artifacts for %s: %v", d.name, err)
 	}
 
+	useCache := d.cache != nil && d.dockerOptions.Cache != nil
+	var cacheKey string
+	var cacheHit bool
+	if useCache {
+		key, fallbackKeys, err := d.cacheKeys()
+		if err != nil {
+			return fmt.Errorf("unable to create cache key for %s: %v", d.name, err)
+		}
+		cacheKey = key
+		cacheHit = d.restoreCache(ctx, cli, key, fallbackKeys)
+	}
+
 	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
 		return fmt.Errorf("unable to start container %s: %v", d.name, err)
 	}
@@ -196,18 +276,44 @@ func (d *DockerRunner) Run(ctx context.Context) error {
 		return fmt.Errorf("error waiting for container %s to stop: %v", d.name, err)
 	case status := <-statusCh:
 		if status.StatusCode != 0 {
-			return fmt.Errorf("container %s exited with status code %d", d.name, status.StatusCode)
+			return fmt.Errorf("container %s %w", d.name, &ExitError{Code: int(status.StatusCode)})
 		}
 		if err := d.publishArtifacts(); err != nil {
 			return fmt.Errorf("unable to publish artifacts for %s: %v", d.name, err)
 		}
+		if useCache && !cacheHit {
+			d.saveCache(ctx, cli, cacheKey)
+		}
 	case <-ctx.Done():
-		return fmt.Errorf("context timed out, stopping container %s", d.name)
+		return fmt.Errorf("%w, stopping container %s", stopReason(ctx), d.name)
 	}
 
 	return nil
 }
 
+// Command returns the command that runs the script. The script lines are run with /bin/sh -c,
+// unless an entrypoint is given, in which case the script is passed to it as a single argument.
+func Command(entrypoint, script []string) []string {
+	commandScript := strings.Join(script, "\n")
+	if len(entrypoint) > 0 {
+		return []string{commandScript}
+	}
+	return []string{"/bin/sh", "-c", commandScript}
+}
+
+func formatEnv(env []models.Variable) []string {
+	variables := make([]string, 0)
+	for _, v := range env {
+		if len(v) > 1 {
+			log.Fatal("variables should be defined as a key value pair")
+		}
+		for k, v := range v {
+			variables = append(variables, fmt.Sprintf("%s=%s", k, fmt.Sprint(v)))
+		}
+	}
+	return variables
+}
+
 func (d *DockerRunner) createSrcDirectories(ctx context.Context, cli *client.Client) error {
 	f, err := os.CreateTemp("", "tarcopy-*.tar")
 	if err != nil {
@@ -224,35 +330,115 @@ func (d *DockerRunner) createSrcDirectories(ctx context.Context, cli *client.Cli
 
 	tar, err := os.Open(f.Name())
 	if err != nil {
-		return nil
+		return err
+	}
+	defer tar.Close()
+
+	// Rootless engines map the container root to the user running the engine, so the files are
+	// given to root to keep them owned by that user on the host.
+	rootless, err := engine.IsRootless(ctx, cli)
+	if err != nil {
+		return err
+	}
+	var content io.Reader = tar
+	if rootless {
+		chowned := utils.ChownTar(tar, 0, 0)
+		defer chowned.Close()
+		content = chowned
 	}
 
-	return cli.CopyToContainer(ctx, d.containerID, WORKING_DIR, tar, types.CopyToContainerOptions{})
+	return cli.CopyToContainer(ctx, d.containerID, WORKING_DIR, content, types.CopyToContainerOptions{})
+}
+
+// removeContainer removes the container of the job. It does not use the job context, so the container
+// is removed when the job is cancelled. If stop is set, the container is stopped first.
+func (d *DockerRunner) removeContainer(cli *client.Client, stop bool) error {
+	ctx := context.Background()
+	if stop {
+		timeout := int(d.dockerOptions.StopTimeout.Seconds())
+		if err := cli.ContainerStop(ctx, d.containerID, container.StopOptions{Timeout: &timeout}); err != nil {
+			return err
+		}
+	}
+	return cli.ContainerRemove(ctx, d.containerID, types.ContainerRemoveOptions{Force: true})
+}
+
+// RemoveContainers removes the containers and networks created by dot that are left behind, for
+// example when dot is killed. It returns the names of the removed containers and networks.
+func RemoveContainers(ctx context.Context, e engine.Engine) ([]string, error) {
+	cli, err := engine.NewClient(e)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", CONTAINER_LABEL)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list containers: %v", err)
+	}
+
+	removed := make([]string, 0, len(containers))
+	for _, v := range containers {
+		if err := cli.ContainerRemove(ctx, v.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return removed, fmt.Errorf("unable to remove container %s: %v", v.ID, err)
+		}
+		name := v.ID
+		if len(v.Names) > 0 {
+			name = strings.TrimPrefix(v.Names[0], "/")
+		}
+		removed = append(removed, name)
+	}
+
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", CONTAINER_LABEL)),
+	})
+	if err != nil {
+		return removed, fmt.Errorf("unable to list networks: %v", err)
+	}
+	for _, v := range networks {
+		if err := cli.NetworkRemove(ctx, v.ID); err != nil {
+			return removed, fmt.Errorf("unable to remove network %s: %v", v.Name, err)
+		}
+		removed = append(removed, v.Name)
+	}
+	return removed, nil
 }
 
 func (d *DockerRunner) publishArtifacts() error {
 	for _, v := range d.artifacts {
-		if _, err := d.artifactManager.PublishArtifact(d.containerID, filepath.Join(WORKING_DIR, v)); err != nil {
+		key, err := d.artifactManager.PublishArtifact(d.jobName, d.containerID, containerArtifact(v))
+		if errors.Is(err, artifacts.ErrNoMatch) && v.Optional {
+			fmt.Fprintf(d.dockerOptions.Stdout, "skipping optional artifact %s: no files match\n", v.Path)
+			continue
+		}
+		if err != nil {
 			return err
 		}
+		d.dockerOptions.Events.send(EventArtifactPublished, "published artifact %s as %s", v.Path, key)
 	}
 	return nil
 }
 
-func (d *DockerRunner) prepareMounts() []mount.Mount {
+// prepareMounts mounts the engine socket at the docker socket path, so tools inside the container
+// work the same way with docker and podman.
+func (d *DockerRunner) prepareMounts(socket string) []mount.Mount {
 	var mounts []mount.Mount
 	if d.dockerOptions.MountDockerSocket {
 		mounts = append(mounts, mount.Mount{
 			Type:   mount.TypeBind,
-			Source: "/var/run/docker.sock",
-			Target: "/var/run/docker.sock",
+			Source: socket,
+			Target: engine.DockerSocket,
 		})
 	}
 	return mounts
 }
 
-func (d *DockerRunner) pullImage(ctx context.Context, cli *client.Client) error {
-	reader, err := cli.ImagePull(ctx, d.image, types.ImagePullOptions{RegistryAuth: d.authConfig})
+func (d *DockerRunner) pull(ctx context.Context, cli *client.Client, image string) error {
+	d.dockerOptions.Events.send(EventImagePullStart, "pulling image %s", image)
+	reader, err := cli.ImagePull(ctx, image, types.ImagePullOptions{RegistryAuth: d.authConfig})
 	if err != nil {
 		return err
 	}
@@ -266,27 +452,25 @@ func (d *DockerRunner) pullImage(ctx context.Context, cli *client.Client) error
 		return err
 	}
 
+	d.dockerOptions.Events.send(EventImagePullEnd, "pulled image %s", image)
 	return nil
 }
 
 func (d *DockerRunner) createContainer(ctx context.Context, cli *client.Client) (container.CreateResponse, error) {
-	commandScript := strings.Join(d.cmd, "\n")
-	cmd := []string{"/bin/sh", "-c", commandScript}
-	if len(d.entrypoint) > 0 {
-		cmd = []string{commandScript}
-	}
-
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
 		Image:      d.image,
 		Env:        d.env,
 		Entrypoint: d.entrypoint,
-		Cmd:        cmd,
+		Cmd:        Command(d.entrypoint, d.cmd),
 		WorkingDir: WORKING_DIR,
+		Labels:     map[string]string{CONTAINER_LABEL: d.jobName},
 	}, &container.HostConfig{
-		Mounts: d.prepareMounts(),
+		Mounts:      d.prepareMounts(engine.SocketPath(cli)),
+		NetworkMode: container.NetworkMode(d.networkID),
 	}, nil, nil, d.name)
 	if err != nil {
 		return container.CreateResponse{}, err
 	}
+	d.dockerOptions.Events.send(EventContainerCreated, "created container %s (%s) from image %s", d.name, resp.ID, d.image)
 	return resp, nil
 }
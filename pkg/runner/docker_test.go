@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/cache"
 	"github.com/opnlabs/dot/pkg/models"
 	"github.com/opnlabs/dot/pkg/utils"
 	"github.com/stretchr/testify/assert"
@@ -26,7 +27,7 @@ type Test struct {
 	Entrypoint  []string
 	Script      []string
 	Variables   []models.Variable
-	Artifacts   []string
+	Artifacts   []models.Artifact
 	Output      io.Writer
 	Ctx         context.Context
 	Expectation func(*testing.T, *bytes.Buffer) bool
@@ -91,8 +92,8 @@ func TestRun(t *testing.T) {
 				"echo TESTING >> log.txt",
 			},
 			Output: &b,
-			Artifacts: []string{
-				"log.txt",
+			Artifacts: []models.Artifact{
+				{Path: "log.txt"},
 			},
 			Expectation: testArtifactCreation,
 			Ctx:         ctx,
@@ -164,7 +165,7 @@ func TestPublishNonExistingFile(t *testing.T) {
 	manager := artifacts.NewDockerArtifactsManager(".artifacts")
 	err := NewDockerRunner("Non existing artifact publish", manager, DockerRunnerOptions{ShowImagePull: false, Stdout: nil, Stderr: nil}).
 		WithImage("docker.io/alpine").
-		CreatesArtifacts([]string{"testing123"}).
+		CreatesArtifacts([]models.Artifact{{Path: "testing123"}}).
 		Run(context.Background())
 	assert.ErrorContains(t, err, "unable to publish artifacts")
 	teardown(t)
@@ -183,6 +184,65 @@ func TestTimeout(t *testing.T) {
 	teardown(t)
 }
 
+func TestServices(t *testing.T) {
+	var b bytes.Buffer
+	manager := artifacts.NewDockerArtifactsManager(".artifacts")
+	err := NewDockerRunner("Test Services", manager, DockerRunnerOptions{ShowImagePull: false, Stdout: &b, Stderr: &b}).
+		WithImage("docker.io/alpine").
+		WithServices([]models.Service{{
+			Image:       "docker.io/redis:alpine",
+			Alias:       "cache",
+			Healthcheck: &models.Healthcheck{Test: []string{"redis-cli ping"}, Interval: time.Second},
+		}}).
+		WithCmd([]string{"nc -z cache 6379 && echo REACHABLE"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "REACHABLE")
+
+	b.Reset()
+	err = NewDockerRunner("Test Services Failure", manager, DockerRunnerOptions{ShowImagePull: false, Stdout: &b, Stderr: &b}).
+		WithImage("docker.io/alpine").
+		WithServices([]models.Service{{Image: "docker.io/redis:alpine"}}).
+		WithCmd([]string{"exit 1"}).
+		Run(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, b.String(), "service redis | ")
+	teardown(t)
+}
+
+func TestWritePrefixed(t *testing.T) {
+	var b bytes.Buffer
+	long := strings.Repeat("a", 100*1024)
+	assert.NoError(t, writePrefixed(&b, "service db | ", strings.NewReader("ready\n"+long+"\nlast")))
+	assert.Equal(t, "service db | ready\nservice db | "+long+"\nservice db | last\n", b.String(), "lines after a long line should be written")
+}
+
+func TestCache(t *testing.T) {
+	var b bytes.Buffer
+	manager := artifacts.NewDockerArtifactsManager(".artifacts")
+	options := DockerRunnerOptions{ShowImagePull: false, Stdout: &b, Stderr: &b, Cache: cache.New(cache.NewDirBackend(t.TempDir()))}
+	jobCache := &models.Cache{Key: "test", Paths: []string{"deps", "/var/cache/test"}}
+
+	err := NewDockerRunner("Test Cache Save", manager, options).
+		WithImage("docker.io/alpine").
+		WithCache(jobCache).
+		WithCmd([]string{"mkdir -p deps && echo CACHED > deps/file"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "saved cache test")
+
+	b.Reset()
+	err = NewDockerRunner("Test Cache Restore", manager, options).
+		WithImage("docker.io/alpine").
+		WithCache(jobCache).
+		WithCmd([]string{"cat deps/file"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "restored cache test")
+	assert.Contains(t, b.String(), "CACHED")
+	teardown(t)
+}
+
 func testImageOutput(t *testing.T, b *bytes.Buffer) bool {
 	str := b.String()
 	lines := strings.Split(str, "\n")
@@ -208,32 +268,43 @@ func testVariableOutput(t *testing.T, b *bytes.Buffer) bool {
 }
 
 func testArtifactCreation(t *testing.T, b *bytes.Buffer) bool {
-	wd, err := os.Getwd()
+	repo, err := artifacts.NewRepository(".artifacts")
 	if err != nil {
 		t.Error(err)
 		return false
 	}
-
-	files, err := os.ReadDir(filepath.Join(wd, ".artifacts"))
+	m, err := repo.Manifest(artifacts.LatestRun)
 	if err != nil {
 		t.Error(err)
 		return false
 	}
-	for _, f := range files {
-		err := utils.DecompressTar(filepath.Join(wd, ".artifacts", f.Name()), filepath.Join(wd, ".artifacts"))
+
+	for _, v := range m.Artifacts {
+		if v.Job != "Test Create Artifact" {
+			continue
+		}
+		r, err := repo.Open(v.SHA256)
 		if err != nil {
 			t.Error(err)
+			return false
+		}
+		dir := t.TempDir()
+		err = utils.ExtractTar(r, dir)
+		r.Close()
+		if err != nil {
+			t.Error(err)
+			return false
 		}
 
-		logFile, err := os.ReadFile(filepath.Join(wd, ".artifacts", "log.txt"))
+		logFile, err := os.ReadFile(filepath.Join(dir, "log.txt"))
 		if err != nil {
 			t.Error(err)
+			return false
 		}
 		testing := regexp.MustCompile(`[^a-zA-Z0-9 ]+`).ReplaceAllString(string(logFile), "")
-		if strings.Compare(strings.TrimSpace(testing), "TESTING") == 0 {
-			return true
-		}
+		return strings.Compare(strings.TrimSpace(testing), "TESTING") == 0
 	}
+	t.Error("artifact of Test Create Artifact not found in the latest run")
 	return false
 }
 
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// Fake is an in-memory backend that records the jobs it runs instead of executing them.
+// It is meant to be used in tests of code that schedules jobs.
+type Fake struct {
+	lock   sync.Mutex
+	ran    []string
+	errors map[string]error
+	delays map[string]time.Duration
+}
+
+type fakeRunner struct {
+	fake *Fake
+	job  models.Job
+	opts Options
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		ran:    make([]string, 0),
+		errors: make(map[string]error),
+		delays: make(map[string]time.Duration),
+	}
+}
+
+// WithError makes the job with the given name fail with err.
+func (f *Fake) WithError(name string, err error) *Fake {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.errors[name] = err
+	return f
+}
+
+// WithDelay makes the job with the given name take d to complete.
+func (f *Fake) WithDelay(name string, d time.Duration) *Fake {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.delays[name] = d
+	return f
+}
+
+// Factory returns a Factory that creates runners backed by f.
+func (f *Fake) Factory() Factory {
+	return func(job models.Job, opts Options) Runner {
+		return &fakeRunner{fake: f, job: job, opts: opts}
+	}
+}
+
+// Ran returns the names of the jobs that completed, in the order they completed.
+func (f *Fake) Ran() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.ran...)
+}
+
+// Run writes the job script to stdout, waits for the configured delay and returns the configured error.
+func (r *fakeRunner) Run(ctx context.Context) error {
+	r.fake.lock.Lock()
+	delay := r.fake.delays[r.job.Name]
+	err := r.fake.errors[r.job.Name]
+	r.fake.lock.Unlock()
+
+	if r.opts.Stdout != nil && len(r.job.Script) > 0 {
+		fmt.Fprintln(r.opts.Stdout, strings.Join(r.job.Script, "\n"))
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return fmt.Errorf("%w, stopping job %s", stopReason(ctx), r.job.Name)
+	}
+
+	r.fake.lock.Lock()
+	defer r.fake.lock.Unlock()
+	r.fake.ran = append(r.fake.ran, r.job.Name)
+	return err
+}
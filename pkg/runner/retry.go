@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// RetryFunc runs a single attempt of a job. Attempts are numbered from 1.
+type RetryFunc func(ctx context.Context, attempt int) error
+
+// AttemptEvents returns the events of an attempt of a job. It can return nil.
+type AttemptEvents func(attempt int) Events
+
+// Retry calls run until it succeeds, fails with an error that the policy does not retry or the attempts
+// of the policy are used up. A nil policy runs the job once. The wait before every retry doubles,
+// starting at the backoff of the policy. The retries are sent to the events of the failed attempt, or
+// logged if it has none. Retry returns the number of attempts made and the last error.
+func Retry(ctx context.Context, name string, policy *models.Retry, events AttemptEvents, run RetryFunc) (int, error) {
+	attempts := 1
+	var backoff time.Duration
+	if policy != nil {
+		attempts = policy.Attempts
+		backoff = policy.Backoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := run(ctx, attempt)
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !Retryable(policy, err) {
+			return attempt, err
+		}
+
+		message := fmt.Sprintf("job %s failed on attempt %d/%d, retrying in %s: %v", name, attempt, attempts, backoff, err)
+		var e Events
+		if events != nil {
+			e = events(attempt)
+		}
+		if e != nil {
+			e(EventRetry, message)
+		} else {
+			log.Print(message)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w while waiting to retry: %v", stopReason(ctx), err)
+		}
+		backoff *= 2
+	}
+}
+
+// Retryable returns true if the policy retries the failure described by err.
+func Retryable(policy *models.Retry, err error) bool {
+	if policy == nil || err == nil {
+		return false
+	}
+
+	var exitErr *ExitError
+	switch {
+	case errors.Is(err, ErrImagePull):
+		return retriesOn(policy, models.RetryImagePull)
+	case errors.Is(err, ErrTimeout):
+		return retriesOn(policy, models.RetryTimeout)
+	case errors.As(err, &exitErr):
+		if !retriesOn(policy, models.RetryExitCode) {
+			return false
+		}
+		if len(policy.ExitCodes) == 0 {
+			return true
+		}
+		for _, v := range policy.ExitCodes {
+			if v == exitErr.Code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func retriesOn(policy *models.Retry, class string) bool {
+	if len(policy.On) == 0 {
+		return true
+	}
+	for _, v := range policy.On {
+		if v == class {
+			return true
+		}
+	}
+	return false
+}
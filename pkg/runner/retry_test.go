@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	policy := &models.Retry{Attempts: 3, Backoff: time.Millisecond}
+
+	attempts, err := Retry(context.Background(), "test", policy, nil, func(ctx context.Context, attempt int) error {
+		if attempt < 2 {
+			return fmt.Errorf("job test %w", &ExitError{Code: 1})
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts, err = Retry(context.Background(), "test", policy, nil, func(ctx context.Context, attempt int) error {
+		return fmt.Errorf("%w for container test", ErrImagePull)
+	})
+	assert.ErrorIs(t, err, ErrImagePull)
+	assert.Equal(t, 3, attempts)
+
+	attempts, err = Retry(context.Background(), "test", nil, nil, func(ctx context.Context, attempt int) error {
+		return ErrTimeout
+	})
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryEvents(t *testing.T) {
+	policy := &models.Retry{Attempts: 2, Backoff: time.Millisecond}
+	var retries []string
+	events := func(attempt int) Events {
+		return func(event Event, message string) {
+			assert.Equal(t, EventRetry, event)
+			retries = append(retries, fmt.Sprintf("%d: %s", attempt, message))
+		}
+	}
+
+	_, err := Retry(context.Background(), "test", policy, events, func(ctx context.Context, attempt int) error {
+		return ErrTimeout
+	})
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, []string{"1: job test failed on attempt 1/2, retrying in 1ms: " + ErrTimeout.Error()}, retries)
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := &models.Retry{Attempts: 3, Backoff: time.Minute}
+
+	attempts, err := Retry(ctx, "test", policy, nil, func(ctx context.Context, attempt int) error {
+		cancel()
+		return ErrTimeout
+	})
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryable(t *testing.T) {
+	exit := func(code int) error { return fmt.Errorf("job test %w", &ExitError{Code: code}) }
+	tests := []struct {
+		policy   *models.Retry
+		err      error
+		expected bool
+	}{
+		{&models.Retry{}, exit(1), true},
+		{&models.Retry{}, errors.New("unable to publish artifacts"), false},
+		{&models.Retry{}, ErrCanceled, false},
+		{&models.Retry{On: []string{models.RetryTimeout}}, ErrTimeout, true},
+		{&models.Retry{On: []string{models.RetryTimeout}}, exit(1), false},
+		{&models.Retry{On: []string{models.RetryExitCode}, ExitCodes: []int{137}}, exit(137), true},
+		{&models.Retry{On: []string{models.RetryExitCode}, ExitCodes: []int{137}}, exit(1), false},
+		{&models.Retry{On: []string{models.RetryImagePull}}, fmt.Errorf("%w: not found", ErrImagePull), true},
+		{nil, exit(1), false},
+	}
+
+	for _, v := range tests {
+		assert.Equal(t, v.expected, Retryable(v.policy, v.err), "%v", v.err)
+	}
+}
@@ -0,0 +1,161 @@
+// Package runner implements the backends that execute the jobs.
+//
+// Docker runner uses the docker runtime to execute jobs. Backends are registered in a Registry
+// and selected with the runner field of a job.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+var (
+	ErrUnknownRunner = errors.New("runner: unknown runner")
+	// ErrCanceled is returned when the job is stopped because its context was cancelled.
+	ErrCanceled = errors.New("runner: job canceled")
+	// ErrTimeout is returned when the job is stopped because its context deadline expired.
+	ErrTimeout = errors.New("runner: context timed out")
+	// ErrImagePull is returned when the image of the job cannot be pulled.
+	ErrImagePull = errors.New("runner: could not pull image")
+)
+
+// ExitError is returned when the job script exits with a non-zero status code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exited with status code %d", e.Code)
+}
+
+// ExitCode returns the status code the job exited with.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// Runner executes a single job.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// stopReason returns the error that describes why a job stopped when ctx is done.
+func stopReason(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return ErrCanceled
+}
+
+// Options holds the configuration passed to a Factory for every job.
+type Options struct {
+	Stdout          io.Writer
+	Stderr          io.Writer
+	ArtifactManager artifacts.ArtifactManager
+	// Env is added to the variables of every job.
+	Env []models.Variable
+	// Events receives the lifecycle events of the job. It can be nil.
+	Events Events
+}
+
+// Event is a step in the lifecycle of a job.
+type Event string
+
+const (
+	EventImagePullStart    Event = "image_pull_start"
+	EventImagePullEnd      Event = "image_pull_end"
+	EventContainerCreated  Event = "container_created"
+	EventArtifactPublished Event = "artifact_published"
+	// EventRetry is sent to the events of an attempt that failed and will be retried.
+	EventRetry Event = "retry"
+)
+
+// Events is called by the runners when a job reaches a step of its lifecycle. The message describes
+// the step for people.
+type Events func(event Event, message string)
+
+// send calls the events function if it is set.
+func (e Events) send(event Event, format string, a ...any) {
+	if e != nil {
+		e(event, fmt.Sprintf(format, a...))
+	}
+}
+
+// failedRunner is returned by a Factory that cannot run the job.
+type failedRunner struct {
+	err error
+}
+
+func (r failedRunner) Run(ctx context.Context) error {
+	return r.err
+}
+
+// containerArtifact returns the artifact with its path and exclude patterns inside WORKING_DIR.
+func containerArtifact(a models.Artifact) models.Artifact {
+	a.Path = path.Join(WORKING_DIR, a.Path)
+	exclude := make([]string, 0, len(a.Exclude))
+	for _, v := range a.Exclude {
+		exclude = append(exclude, path.Join(WORKING_DIR, v))
+	}
+	a.Exclude = exclude
+	return a
+}
+
+// Factory creates a Runner that executes the job.
+type Factory func(job models.Job, opts Options) Runner
+
+// Registry maps runner names to the factories that create them.
+type Registry struct {
+	factories     map[string]Factory
+	defaultRunner string
+}
+
+// NewRegistry creates an empty registry. Jobs that do not specify a runner use defaultRunner.
+func NewRegistry(defaultRunner string) *Registry {
+	return &Registry{
+		factories:     make(map[string]Factory),
+		defaultRunner: defaultRunner,
+	}
+}
+
+// Register adds a factory under the given name, replacing any factory previously registered with that name.
+func (r *Registry) Register(name string, factory Factory) {
+	r.factories[name] = factory
+}
+
+// Names returns the sorted names of the registered runners.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.factories))
+	for k := range r.factories {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the name of the runner that executes the job.
+func (r *Registry) Resolve(job models.Job) (string, error) {
+	name := job.Runner
+	if len(name) == 0 {
+		name = r.defaultRunner
+	}
+	if _, ok := r.factories[name]; !ok {
+		return "", fmt.Errorf("%w %q for job %s", ErrUnknownRunner, name, job.Name)
+	}
+	return name, nil
+}
+
+// New creates a Runner for the job using the runner named in the job or the default runner.
+func (r *Registry) New(job models.Job, opts Options) (Runner, error) {
+	name, err := r.Resolve(job)
+	if err != nil {
+		return nil, err
+	}
+	return r.factories[name](job, opts), nil
+}
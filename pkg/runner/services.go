@@ -0,0 +1,203 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+// healthInterval is how often the state of the services is checked while waiting for them.
+const healthInterval = 500 * time.Millisecond
+
+type service struct {
+	models.Service
+	containerID string
+}
+
+// WithServices specifies the containers started next to the job. They run on a network created for
+// the job, where they are reachable by their alias.
+func (d *DockerRunner) WithServices(services []models.Service) *DockerRunner {
+	d.services = make([]*service, 0, len(services))
+	for _, v := range services {
+		d.services = append(d.services, &service{Service: v})
+	}
+	return d
+}
+
+// createNetwork creates the network shared by the job and its services.
+func (d *DockerRunner) createNetwork(ctx context.Context, cli *client.Client) error {
+	resp, err := cli.NetworkCreate(ctx, d.name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         map[string]string{CONTAINER_LABEL: d.jobName},
+	})
+	if err != nil {
+		return err
+	}
+	d.networkID = resp.ID
+	return nil
+}
+
+// startServices pulls, creates and starts the services. The containers that were created are removed
+// by removeServices, even if starting one of them failed.
+func (d *DockerRunner) startServices(ctx context.Context, cli *client.Client) error {
+	for _, s := range d.services {
+		if err := d.pull(ctx, cli, s.Image); err != nil {
+			return fmt.Errorf("%w %s for service %s: %v", ErrImagePull, s.Image, s.Hostname(), err)
+		}
+
+		resp, err := cli.ContainerCreate(ctx, &container.Config{
+			Image:       s.Image,
+			Env:         formatEnv(s.Variables),
+			Entrypoint:  s.Entrypoint,
+			Cmd:         s.Command,
+			Labels:      map[string]string{CONTAINER_LABEL: d.jobName},
+			Healthcheck: healthConfig(s.Healthcheck),
+		}, &container.HostConfig{}, &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				d.name: {Aliases: []string{s.Hostname()}},
+			},
+		}, nil, fmt.Sprintf("%s-%s", d.name, s.Hostname()))
+		if err != nil {
+			return fmt.Errorf("unable to create service %s: %v", s.Hostname(), err)
+		}
+		s.containerID = resp.ID
+
+		if err := cli.ContainerStart(ctx, s.containerID, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("unable to start service %s: %v", s.Hostname(), err)
+		}
+	}
+
+	for _, s := range d.services {
+		if err := d.waitHealthy(ctx, cli, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitHealthy waits for the healthcheck of the service to pass. Services without a healthcheck, in the
+// job or in the image, are ready once they are running.
+func (d *DockerRunner) waitHealthy(ctx context.Context, cli *client.Client, s *service) error {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := cli.ContainerInspect(ctx, s.containerID)
+		if err != nil {
+			return fmt.Errorf("unable to inspect service %s: %v", s.Hostname(), err)
+		}
+		if !info.State.Running {
+			return fmt.Errorf("service %s exited with status code %d", s.Hostname(), info.State.ExitCode)
+		}
+		if info.State.Health == nil || info.State.Health.Status == types.Healthy {
+			return nil
+		}
+		if info.State.Health.Status == types.Unhealthy {
+			return fmt.Errorf("service %s is unhealthy", s.Hostname())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w while waiting for service %s to be healthy", stopReason(ctx), s.Hostname())
+		}
+	}
+}
+
+// writeServiceLogs writes the logs of every service to w, prefixed with the alias of the service.
+func (d *DockerRunner) writeServiceLogs(cli *client.Client, w io.Writer) {
+	for _, s := range d.services {
+		if len(s.containerID) == 0 {
+			continue
+		}
+		logs, err := cli.ContainerLogs(context.Background(), s.containerID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+		})
+		if err != nil {
+			fmt.Fprintf(w, "service %s | unable to read logs: %v\n", s.Hostname(), err)
+			continue
+		}
+
+		var b bytes.Buffer
+		_, err = stdcopy.StdCopy(&b, &b, logs)
+		logs.Close()
+		if err != nil {
+			fmt.Fprintf(w, "service %s | unable to read logs: %v\n", s.Hostname(), err)
+		}
+		if err := writePrefixed(w, fmt.Sprintf("service %s | ", s.Hostname()), &b); err != nil {
+			fmt.Fprintf(w, "service %s | unable to write logs: %v\n", s.Hostname(), err)
+		}
+	}
+}
+
+// writePrefixed writes every line of r to w after the prefix. Lines of any length are written whole.
+func writePrefixed(w io.Writer, prefix string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			if _, err := fmt.Fprintf(w, "%s%s\n", prefix, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// removeServices removes the service containers and the network of the job.
+func (d *DockerRunner) removeServices(cli *client.Client) error {
+	ctx := context.Background()
+	var firstErr error
+	for _, s := range d.services {
+		if len(s.containerID) == 0 {
+			continue
+		}
+		if err := cli.ContainerRemove(ctx, s.containerID, types.ContainerRemoveOptions{Force: true}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(d.networkID) > 0 {
+		if err := cli.NetworkRemove(ctx, d.networkID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func healthConfig(h *models.Healthcheck) *container.HealthConfig {
+	if h == nil || len(h.Test) == 0 {
+		return nil
+	}
+	test := h.Test
+	switch {
+	case len(test) == 1:
+		test = []string{"CMD-SHELL", test[0]}
+	case test[0] != "CMD" && test[0] != "CMD-SHELL" && test[0] != "NONE":
+		test = append([]string{"CMD"}, test...)
+	}
+	return &container.HealthConfig{
+		Test:        test,
+		Interval:    h.Interval,
+		Timeout:     h.Timeout,
+		StartPeriod: h.StartPeriod,
+		Retries:     h.Retries,
+	}
+}
@@ -0,0 +1,202 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/gosimple/slug"
+	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/utils"
+	"github.com/rs/xid"
+)
+
+type ShellRunnerOptions struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	// Events receives the lifecycle events of the job. It can be nil.
+	Events Events
+	// InPlace runs the job directly in src instead of a temporary copy of it.
+	InPlace bool
+	// StopTimeout is the time the script is given to exit after SIGTERM when the job is stopped,
+	// before it is killed.
+	StopTimeout time.Duration
+}
+
+// ShellRunner runs jobs with /bin/sh on the host without a container.
+// The job directory takes the place of WORKING_DIR, so artifacts can be shared with DockerRunner
+// when both use artifact managers backed by the same directory.
+type ShellRunner struct {
+	name            string
+	jobName         string
+	src             string
+	env             []string
+	cmd             []string
+	entrypoint      []string
+	artifacts       []models.Artifact
+	dependencies    []string
+	artifactManager artifacts.ArtifactManager
+	shellOptions    ShellRunnerOptions
+}
+
+func NewShellRunner(name string, artifactManager artifacts.ArtifactManager, shellOptions ShellRunnerOptions) *ShellRunner {
+	if shellOptions.Stdout == nil {
+		shellOptions.Stdout = os.Stdout
+	}
+	if shellOptions.Stderr == nil {
+		shellOptions.Stderr = os.Stderr
+	}
+
+	return &ShellRunner{
+		name:            slug.Make(fmt.Sprintf("%s-%s", name, xid.New().String())),
+		jobName:         name,
+		src:             filepath.Clean(""),
+		artifactManager: artifactManager,
+		shellOptions:    shellOptions,
+	}
+}
+
+// NewShellFactory returns a Factory that creates a ShellRunner for each job.
+// The image of the job is ignored. Jobs with services cannot run on the host and fail.
+func NewShellFactory(shellOptions ShellRunnerOptions) Factory {
+	return func(job models.Job, opts Options) Runner {
+		if len(job.Services) > 0 {
+			return failedRunner{err: fmt.Errorf("job %s has services, which are not supported by the shell runner", job.Name)}
+		}
+
+		options := shellOptions
+		options.Stdout = opts.Stdout
+		options.Stderr = opts.Stderr
+		options.Events = opts.Events
+
+		return NewShellRunner(job.Name, opts.ArtifactManager, options).
+			WithSrc(job.Src).
+			WithCmd(job.Script).
+			WithEntrypoint(job.Entrypoint).
+			WithEnv(append(job.Variables, opts.Env...)).
+			CreatesArtifacts(job.Artifacts).
+			WithDependencies(job.Dependencies)
+	}
+}
+
+// WithSrc takes the src location and returns a Shell runner.
+// Unless InPlace is set, src is copied into a temporary directory where the job is run.
+func (s *ShellRunner) WithSrc(src string) *ShellRunner {
+	s.src = filepath.Clean(src)
+	return s
+}
+
+// WithEnv is used to specify job variables. They are added to the environment of the dot process.
+// Env is an array of map[string]any. The length of the map should be 1.
+func (s *ShellRunner) WithEnv(env []models.Variable) *ShellRunner {
+	s.env = formatEnv(env)
+	return s
+}
+
+// WithCmd specifies the script that should be run by the shell.
+func (s *ShellRunner) WithCmd(cmd []string) *ShellRunner {
+	s.cmd = cmd
+	return s
+}
+
+// WithEntrypoint can be used to run the script with a program other than /bin/sh -c.
+func (s *ShellRunner) WithEntrypoint(entrypoint []string) *ShellRunner {
+	s.entrypoint = entrypoint
+	return s
+}
+
+// CreatesArtifacts is used to specify the files that will be stored as artifacts.
+// The paths are relative to the src specified in WithSrc. Artifacts without a name are published
+// under the name of the job.
+func (s *ShellRunner) CreatesArtifacts(artifacts []models.Artifact) *ShellRunner {
+	s.artifacts = artifacts
+	return s
+}
+
+// WithDependencies specifies the jobs or artifact names whose artifacts are copied into the job.
+// All the artifacts are copied if dependencies is nil, and none if it is empty.
+func (s *ShellRunner) WithDependencies(dependencies []string) *ShellRunner {
+	s.dependencies = dependencies
+	return s
+}
+
+// Run executes the script in the job directory.
+func (s *ShellRunner) Run(ctx context.Context) error {
+	dir, err := s.createJobDirectory()
+	if err != nil {
+		return fmt.Errorf("unable to create source directories for %s: %v", s.name, err)
+	}
+	if !s.shellOptions.InPlace {
+		defer os.RemoveAll(dir)
+	}
+
+	if err := s.artifactManager.RetrieveArtifact(dir, s.dependencies); err != nil {
+		return fmt.Errorf("unable to retrieve artifacts for %s: %v", s.name, err)
+	}
+
+	args := append(append([]string{}, s.entrypoint...), Command(s.entrypoint, s.cmd)...)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), s.env...)
+	cmd.Stdout = s.shellOptions.Stdout
+	cmd.Stderr = s.shellOptions.Stderr
+	stop := setProcessGroup(cmd, s.shellOptions.StopTimeout)
+
+	err = cmd.Run()
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w, stopping job %s", stopReason(ctx), s.name)
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("job %s %w", s.name, &ExitError{Code: exitErr.ExitCode()})
+		}
+		return fmt.Errorf("unable to run job %s: %v", s.name, err)
+	}
+
+	if err := s.publishArtifacts(dir); err != nil {
+		return fmt.Errorf("unable to publish artifacts for %s: %v", s.name, err)
+	}
+	return nil
+}
+
+func (s *ShellRunner) createJobDirectory() (string, error) {
+	if s.shellOptions.InPlace {
+		if _, err := os.Stat(s.src); err != nil {
+			return "", err
+		}
+		return filepath.Abs(s.src)
+	}
+
+	dir, err := os.MkdirTemp("", s.name+"-*")
+	if err != nil {
+		return "", err
+	}
+	if err := utils.CopyDir(s.src, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func (s *ShellRunner) publishArtifacts(dir string) error {
+	for _, v := range s.artifacts {
+		key, err := s.artifactManager.PublishArtifact(s.jobName, dir, containerArtifact(v))
+		if errors.Is(err, artifacts.ErrNoMatch) && v.Optional {
+			fmt.Fprintf(s.shellOptions.Stdout, "skipping optional artifact %s: no files match\n", v.Path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.shellOptions.Events.send(EventArtifactPublished, "published artifact %s as %s", v.Path, key)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package runner
+
+import (
+	"os/exec"
+	"time"
+)
+
+func setProcessGroup(cmd *exec.Cmd, grace time.Duration) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/artifacts"
+	"github.com/opnlabs/dot/pkg/models"
+	"github.com/opnlabs/dot/pkg/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFilesystemArtifactsManager(t *testing.T) artifacts.ArtifactManager {
+	repo, err := artifacts.NewRepository(t.TempDir())
+	assert.NoError(t, err)
+	run, err := repo.NewRun(artifacts.NewRunID())
+	assert.NoError(t, err)
+	return artifacts.NewFilesystemArtifactsManager(run, store.NewMemStore(store.Isolated()), WORKING_DIR)
+}
+
+func TestShellRun(t *testing.T) {
+	var b bytes.Buffer
+	manager := newFilesystemArtifactsManager(t)
+
+	err := NewShellRunner("Test Shell Variables", manager, ShellRunnerOptions{Stdout: &b}).
+		WithEnv([]models.Variable{{"TESTING_VARIABLE": "TESTING"}}).
+		WithCmd([]string{"echo $TESTING_VARIABLE"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "TESTING", strings.TrimSpace(b.String()))
+
+	b.Reset()
+	err = NewShellRunner("Test Shell Entrypoint", manager, ShellRunnerOptions{Stdout: &b}).
+		WithEntrypoint([]string{"echo"}).
+		WithCmd([]string{"TESTING"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "TESTING", strings.TrimSpace(b.String()))
+
+	teardown(t)
+}
+
+func TestShellArtifacts(t *testing.T) {
+	var b bytes.Buffer
+	manager := newFilesystemArtifactsManager(t)
+
+	var events []Event
+	options := ShellRunnerOptions{Stdout: &b, Events: func(event Event, message string) {
+		events = append(events, event)
+	}}
+	err := NewShellRunner("Test Shell Create Artifact", manager, options).
+		WithCmd([]string{"mkdir -p out", "echo TESTING > out/log.txt"}).
+		CreatesArtifacts([]models.Artifact{{Path: "out"}}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{EventArtifactPublished}, events)
+
+	err = NewShellRunner("Test Shell Retrieve Artifact", manager, ShellRunnerOptions{Stdout: &b}).
+		WithCmd([]string{"cat out/log.txt"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "TESTING", strings.TrimSpace(b.String()))
+
+	_, err = os.Stat(filepath.Join("out", "log.txt"))
+	assert.True(t, os.IsNotExist(err), "job should not modify the source directory")
+
+	teardown(t)
+}
+
+func TestShellArtifactPatterns(t *testing.T) {
+	var b bytes.Buffer
+	manager := newFilesystemArtifactsManager(t)
+
+	err := NewShellRunner("Test Shell Create Patterns", manager, ShellRunnerOptions{Stdout: &b}).
+		WithCmd([]string{
+			"mkdir -p dist/tmp coverage/a/b",
+			"echo APP > dist/app.tar.gz", "echo TMP > dist/tmp/big.tar.gz", "echo LOG > dist/build.log",
+			"echo A > coverage/a/a.out", "echo B > coverage/a/b/b.out", "echo TXT > coverage/a/b/b.txt",
+		}).
+		CreatesArtifacts([]models.Artifact{
+			{Path: "dist/**/*.tar.gz", Exclude: []string{"dist/tmp"}},
+			{Path: "coverage/**/*.out"},
+			{Path: "reports/*.xml", Optional: true},
+		}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "skipping optional artifact reports/*.xml")
+
+	err = NewShellRunner("Test Shell Retrieve Patterns", manager, ShellRunnerOptions{Stdout: &b}).
+		WithCmd([]string{
+			"test -f dist/app.tar.gz", "test ! -e dist/tmp", "test ! -e dist/build.log",
+			"test -f coverage/a/a.out", "test -f coverage/a/b/b.out", "test ! -e coverage/a/b/b.txt",
+		}).
+		Run(context.Background())
+	assert.NoError(t, err)
+
+	err = NewShellRunner("Test Shell Missing Pattern", manager, ShellRunnerOptions{Stdout: &b}).
+		WithCmd([]string{"mkdir -p dist"}).
+		CreatesArtifacts([]models.Artifact{{Path: "dist/*.zip"}}).
+		Run(context.Background())
+	assert.ErrorContains(t, err, "no files match the artifact path: /app/dist/*.zip")
+	teardown(t)
+}
+
+func TestShellDependencies(t *testing.T) {
+	var b bytes.Buffer
+	manager := newFilesystemArtifactsManager(t)
+
+	err := NewShellRunner("Test Shell Build", manager, ShellRunnerOptions{Stdout: &b}).
+		WithCmd([]string{"mkdir -p out", "echo BUILD > out/log.txt"}).
+		CreatesArtifacts([]models.Artifact{{Path: "out"}}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	err = NewShellRunner("Test Shell Docs", manager, ShellRunnerOptions{Stdout: &b}).
+		WithCmd([]string{"mkdir -p public", "echo DOCS > public/index.html"}).
+		CreatesArtifacts([]models.Artifact{{Name: "site", Path: "public"}}).
+		Run(context.Background())
+	assert.NoError(t, err)
+
+	b.Reset()
+	err = NewShellRunner("Test Shell Build Dependency", manager, ShellRunnerOptions{Stdout: &b}).
+		WithDependencies([]string{"Test Shell Build"}).
+		WithCmd([]string{"cat out/log.txt"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "BUILD", strings.TrimSpace(b.String()), "artifacts should be published under the name of the job")
+
+	b.Reset()
+	err = NewShellRunner("Test Shell Dependencies", manager, ShellRunnerOptions{Stdout: &b}).
+		WithDependencies([]string{"site"}).
+		WithCmd([]string{"cat public/index.html", "test ! -e out"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "DOCS", strings.TrimSpace(b.String()))
+
+	err = NewShellRunner("Test Shell No Dependencies", manager, ShellRunnerOptions{Stdout: &b}).
+		WithDependencies([]string{}).
+		WithCmd([]string{"test ! -e out && test ! -e public"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	teardown(t)
+}
+
+func TestShellExitCode(t *testing.T) {
+	manager := newFilesystemArtifactsManager(t)
+	err := NewShellRunner("Test Shell Exit Code", manager, ShellRunnerOptions{}).
+		WithCmd([]string{"exit 3"}).
+		Run(context.Background())
+	assert.ErrorContains(t, err, "exited with status code 3")
+
+	err = NewShellRunner("Test Shell Missing Artifact", manager, ShellRunnerOptions{}).
+		CreatesArtifacts([]models.Artifact{{Path: "testing123"}}).
+		Run(context.Background())
+	assert.ErrorContains(t, err, "unable to publish artifacts")
+	teardown(t)
+}
+
+func TestShellInPlace(t *testing.T) {
+	var b bytes.Buffer
+	manager := newFilesystemArtifactsManager(t)
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "input.txt"), []byte("TESTING"), 0644))
+
+	err := NewShellRunner("Test Shell In Place", manager, ShellRunnerOptions{Stdout: &b, InPlace: true}).
+		WithSrc(src).
+		WithCmd([]string{"cat input.txt", "echo OUTPUT > output.txt"}).
+		Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "TESTING", strings.TrimSpace(b.String()))
+	_, err = os.Stat(filepath.Join(src, "output.txt"))
+	assert.NoError(t, err, "job should run in src")
+	teardown(t)
+}
+
+func TestShellTimeout(t *testing.T) {
+	manager := newFilesystemArtifactsManager(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := NewShellRunner("Test Shell Timeout", manager, ShellRunnerOptions{}).
+		WithCmd([]string{"sleep 60"}).
+		Run(ctx)
+	assert.ErrorContains(t, err, "context timed out")
+	teardown(t)
+}
+
+func TestShellCancelGracePeriod(t *testing.T) {
+	var b bytes.Buffer
+	manager := newFilesystemArtifactsManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	err := NewShellRunner("Test Shell Cancel", manager, ShellRunnerOptions{Stdout: &b, StopTimeout: 5 * time.Second}).
+		WithCmd([]string{"trap 'echo STOPPED; exit 1' TERM", "sleep 60 & wait"}).
+		Run(ctx)
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.Equal(t, "STOPPED", strings.TrimSpace(b.String()))
+	teardown(t)
+}
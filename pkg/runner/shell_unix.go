@@ -0,0 +1,40 @@
+//go:build unix
+
+package runner
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup runs the job in its own process group so that cancelling the job
+// also stops the processes started by the script. The group gets SIGTERM and is killed
+// once the grace period is over. The returned function must be called once the command
+// has been waited for, so a script that exited in time does not get its process group
+// ID, which may belong to another process by then, killed later.
+func setProcessGroup(cmd *exec.Cmd, grace time.Duration) (stop func()) {
+	var lock sync.Mutex
+	var kill *time.Timer
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pid := cmd.Process.Pid
+		if grace <= 0 {
+			return syscall.Kill(-pid, syscall.SIGKILL)
+		}
+		lock.Lock()
+		kill = time.AfterFunc(grace, func() {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		})
+		lock.Unlock()
+		return syscall.Kill(-pid, syscall.SIGTERM)
+	}
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if kill != nil {
+			kill.Stop()
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package schema generates a JSON Schema for the job file from the models and their validate tags.
+//
+// The schema can be used by editors to provide autocompletion and validation for dot.yml.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opnlabs/dot/pkg/models"
+)
+
+const draft = "http://json-schema.org/draft-07/schema#"
+
+// overrides holds the schemas of types that are not decoded from their fields.
+var overrides = map[reflect.Type]func() map[string]any{
+	reflect.TypeOf(models.Variable{}): func() map[string]any {
+		return map[string]any{
+			"type":                 "object",
+			"description":          "A single KEY: value pair.",
+			"minProperties":        1,
+			"maxProperties":        1,
+			"additionalProperties": scalar(),
+		}
+	},
+	reflect.TypeOf(time.Duration(0)): func() map[string]any {
+		return map[string]any{
+			"type":        "string",
+			"description": "A duration like 90s, 10m or 1h30m.",
+			"pattern":     `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	},
+	reflect.TypeOf(models.AllowFailure{}): func() map[string]any {
+		return map[string]any{
+			"description": "true, or the exit codes the job is allowed to fail with.",
+			"oneOf": []any{
+				map[string]any{"type": "boolean"},
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"exit_codes": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+					},
+					"additionalProperties": false,
+				},
+			},
+		}
+	},
+	reflect.TypeOf(models.Artifact{}): func() map[string]any {
+		return map[string]any{
+			"description": "The path or glob pattern to publish, or a mapping with the name used in dependencies, the path, the patterns to exclude and whether the artifact is optional.",
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":     map[string]any{"type": "string"},
+						"path":     map[string]any{"type": "string"},
+						"exclude":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+						"optional": map[string]any{"type": "boolean"},
+					},
+					"required":             []any{"path"},
+					"additionalProperties": false,
+				},
+			},
+		}
+	},
+	reflect.TypeOf(models.Matrix{}): func() map[string]any {
+		combinations := map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "object", "additionalProperties": scalar()},
+		}
+		return map[string]any{
+			"type":        "object",
+			"description": "Variables and the list of values they take. The job runs once for every combination.",
+			"properties": map[string]any{
+				"include": combinations,
+				"exclude": combinations,
+			},
+			"additionalProperties": map[string]any{"type": "array", "items": scalar()},
+		}
+	},
+}
+
+// Generate returns the JSON Schema of the job file.
+func Generate() ([]byte, error) {
+	s, err := generate(reflect.TypeOf(models.JobFile{}))
+	if err != nil {
+		return nil, err
+	}
+	s["$schema"] = draft
+	s["title"] = "Dot job file"
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func scalar() map[string]any {
+	return map[string]any{"type": []string{"string", "number", "boolean"}}
+}
+
+func generate(t reflect.Type) (map[string]any, error) {
+	if override, ok := overrides[t]; ok {
+		return override(), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return generate(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Slice:
+		items, err := generate(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := generate(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+	case reflect.Struct:
+		return generateStruct(t)
+	}
+	return nil, fmt.Errorf("schema: unsupported type %s", t)
+}
+
+func generateStruct(t reflect.Type) (map[string]any, error) {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+	conditions := make([]any, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := yamlName(field)
+		if len(name) == 0 {
+			continue
+		}
+
+		s, err := generate(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("schema: field %s: %v", field.Name, err)
+		}
+		properties[name] = s
+
+		// target is the schema the tag applies to. Tags after dive apply to the items of a list.
+		target := s
+		for _, tag := range strings.Split(field.Tag.Get("validate"), ",") {
+			switch {
+			case tag == "dive":
+				if items, ok := target["items"].(map[string]any); ok {
+					target = items
+				}
+			case strings.HasPrefix(tag, "gte=") && target["type"] == "integer":
+				minimum, err := strconv.Atoi(strings.TrimPrefix(tag, "gte="))
+				if err != nil {
+					return nil, fmt.Errorf("schema: unsupported tag %s on field %s", tag, field.Name)
+				}
+				target["minimum"] = minimum
+			case strings.HasPrefix(tag, "oneof="):
+				target["enum"] = strings.Fields(strings.TrimPrefix(tag, "oneof="))
+			case tag == "required":
+				required = append(required, name)
+			case strings.HasPrefix(tag, "required_unless="):
+				// required_unless=Field value is required unless the other field has the value
+				param := strings.Fields(strings.TrimPrefix(tag, "required_unless="))
+				if len(param) != 2 {
+					return nil, fmt.Errorf("schema: unsupported tag %s on field %s", tag, field.Name)
+				}
+				other, ok := t.FieldByName(param[0])
+				if !ok {
+					return nil, fmt.Errorf("schema: unknown field %s in tag on field %s", param[0], field.Name)
+				}
+				conditions = append(conditions, map[string]any{
+					"if": map[string]any{
+						"properties": map[string]any{yamlName(other): map[string]any{"const": param[1]}},
+						"required":   []string{yamlName(other)},
+					},
+					"else": map[string]any{"required": []string{name}},
+				})
+			}
+		}
+	}
+
+	s := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	if len(conditions) > 0 {
+		s["allOf"] = conditions
+	}
+	return s, nil
+}
+
+// yamlName returns the key used for the field in yaml, following the rules of the yaml package.
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
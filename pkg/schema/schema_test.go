@@ -0,0 +1,44 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaFile is the schema published in the repository.
+var schemaFile = filepath.Join("..", "..", "dot.schema.json")
+
+func TestSchemaUpToDate(t *testing.T) {
+	published, err := os.ReadFile(schemaFile)
+	assert.NoError(t, err)
+
+	generated, err := Generate()
+	assert.NoError(t, err)
+	assert.Equal(t, string(generated), string(published),
+		"dot.schema.json is out of date, regenerate it with: go run main.go schema > dot.schema.json")
+}
+
+func TestSchemaRequiredFields(t *testing.T) {
+	generated, err := Generate()
+	assert.NoError(t, err)
+
+	var s struct {
+		Required   []string `json:"required"`
+		Properties struct {
+			Jobs struct {
+				Items struct {
+					Required   []string       `json:"required"`
+					Properties map[string]any `json:"properties"`
+				} `json:"items"`
+			} `json:"jobs"`
+		} `json:"properties"`
+	}
+	assert.NoError(t, json.Unmarshal(generated, &s))
+	assert.Equal(t, []string{"stages", "jobs"}, s.Required)
+	assert.Equal(t, []string{"name", "stage"}, s.Properties.Jobs.Items.Required)
+	assert.Contains(t, s.Properties.Jobs.Items.Properties, "needs")
+}
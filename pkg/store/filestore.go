@@ -0,0 +1,466 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compactMinEntries is the number of entries in the log before it is compacted.
+const compactMinEntries = 128
+
+// entry is a line of the log. Deleted keys have no value.
+type entry struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Expires *time.Time      `json:"expires,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	// Expired is set on the deletes of expired keys.
+	Expired bool `json:"expired,omitempty"`
+	// ExpiryOnly is set on the lines of Expire, which keep the value of the key.
+	ExpiryOnly bool `json:"expiry_only,omitempty"`
+}
+
+// fileItem is the value of a key and its expiry.
+type fileItem struct {
+	value   json.RawMessage
+	expires time.Time
+}
+
+// FileStore keeps the keys in an append-only log of JSON lines, so they outlive the process.
+// Values are stored as JSON, and Get returns them as decoded by encoding/json into an interface{}:
+// numbers are float64, and structs are map[string]interface{}.
+//
+// Every change is synced to disk before it returns, and a line left incomplete by a crash is
+// discarded. Several processes can share the file. They take a lock on path.lock for every operation
+// and read the changes made by the others before applying theirs. The log is rewritten with one line
+// per key once it holds more than twice as many lines as keys.
+//
+// Expired keys are deleted by a timer of the store, and are ignored until then. Watch sends the changes
+// made through the store, and the changes of other processes once the store reads them, which happens
+// on its next operation.
+type FileStore struct {
+	lock     sync.Mutex
+	path     string
+	file     *os.File
+	lockFile *os.File
+	closed   bool
+	// offset is the end of the last complete line that was read.
+	offset   int64
+	entries  int
+	store    map[string]fileItem
+	timers   map[string]*time.Timer
+	watchers watchers
+}
+
+// NewFileStore opens the log at path, creating it if it does not exist.
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create store directory: %v", err)
+	}
+	lockFile, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open store lock: %v", err)
+	}
+
+	f := &FileStore{path: path, lockFile: lockFile, timers: make(map[string]*time.Timer)}
+	if err := f.withLock(false, func() error { return nil }); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close releases the files of the store.
+func (f *FileStore) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closed = true
+	for _, v := range f.timers {
+		v.Stop()
+	}
+	err := f.lockFile.Close()
+	if f.file != nil {
+		err = errors.Join(err, f.file.Close())
+	}
+	return err
+}
+
+// Set is used to set a value to a key.
+func (f *FileStore) Set(key string, value interface{}) error {
+	return f.withLock(true, func() error {
+		if _, ok := f.lookup(key); ok {
+			return ErrKeyExists
+		}
+		return f.write(key, value, time.Time{})
+	})
+}
+
+// Get is used to get a value from a key.
+func (f *FileStore) Get(key string) (interface{}, error) {
+	var value interface{}
+	err := f.withLock(false, func() error {
+		it, ok := f.lookup(key)
+		if !ok {
+			return ErrKeyDoesntExist
+		}
+		return json.Unmarshal(it.value, &value)
+	})
+	return value, err
+}
+
+// Delete removes the specified key and value.
+func (f *FileStore) Delete(key string) error {
+	return f.withLock(true, func() error {
+		if _, ok := f.lookup(key); !ok {
+			return ErrKeyDoesntExist
+		}
+		return f.append(entry{Key: key, Deleted: true})
+	})
+}
+
+// Update can be used to change the value for a given key.
+func (f *FileStore) Update(key string, value interface{}) error {
+	return f.withLock(true, func() error {
+		it, ok := f.lookup(key)
+		if !ok {
+			return ErrKeyDoesntExist
+		}
+		return f.write(key, value, it.expires)
+	})
+}
+
+// List returns the keys that start with prefix and their values, sorted by key.
+func (f *FileStore) List(prefix string) ([]KeyValue, error) {
+	list := make([]KeyValue, 0)
+	err := f.withLock(false, func() error {
+		for k := range f.store {
+			it, ok := f.lookup(k)
+			if !ok || !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal(it.value, &value); err != nil {
+				return err
+			}
+			list = append(list, KeyValue{Key: k, Value: value})
+		}
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list, err
+}
+
+// CompareAndSwap replaces the value of the key with newValue if its value is equal to old. Both values
+// are compared once encoded to and decoded from JSON, like the values returned by Get.
+func (f *FileStore) CompareAndSwap(key string, old, newValue interface{}) (bool, error) {
+	swapped := false
+	err := f.withLock(true, func() error {
+		it, ok := f.lookup(key)
+		if !ok {
+			return ErrKeyDoesntExist
+		}
+		b, err := json.Marshal(old)
+		if err != nil {
+			return fmt.Errorf("could not encode the old value of %s: %v", key, err)
+		}
+		var current, expected interface{}
+		if err := json.Unmarshal(it.value, &current); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &expected); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(current, expected) {
+			return nil
+		}
+		swapped = true
+		return f.write(key, newValue, it.expires)
+	})
+	return swapped, err
+}
+
+// Expire deletes the key once ttl has passed. A ttl of 0 removes the expiry.
+func (f *FileStore) Expire(key string, ttl time.Duration) error {
+	return f.withLock(true, func() error {
+		it, ok := f.lookup(key)
+		if !ok {
+			return ErrKeyDoesntExist
+		}
+		e := entry{Key: key, Value: it.value, ExpiryOnly: true}
+		if ttl > 0 {
+			expires := time.Now().Add(ttl)
+			e.Expires = &expires
+		}
+		return f.append(e)
+	})
+}
+
+// Watch returns the changes to the keys that start with prefix until ctx is done.
+func (f *FileStore) Watch(ctx context.Context, prefix string) <-chan Event {
+	return f.watchers.add(ctx, prefix)
+}
+
+// lookup returns the item of the key unless it expired.
+func (f *FileStore) lookup(key string) (fileItem, bool) {
+	it, ok := f.store[key]
+	if !ok || expired(it.expires) {
+		return fileItem{}, false
+	}
+	return it, true
+}
+
+// withLock runs fn with the changes of the other processes applied, while holding the lock of the file.
+// Writers take an exclusive lock.
+func (f *FileStore) withLock(exclusive bool, fn func() error) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return fmt.Errorf("store %s is closed", f.path)
+	}
+
+	if err := lockFile(f.lockFile, exclusive); err != nil {
+		return fmt.Errorf("could not lock store %s: %v", f.path, err)
+	}
+	defer unlockFile(f.lockFile)
+
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// refresh reads the lines appended since the last call. The log is read again from the start when it
+// was replaced by a compaction, and the watchers get the differences with the keys read before.
+func (f *FileStore) refresh() error {
+	info, err := os.Stat(f.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if f.file != nil && info != nil {
+		current, err := f.file.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(info, current) {
+			f.file.Close()
+			f.file = nil
+		}
+	}
+
+	// previous is set when the log was replaced, to notify the watchers once it is read again.
+	var previous map[string]fileItem
+	if f.file == nil {
+		file, err := os.OpenFile(f.path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open store %s: %v", f.path, err)
+		}
+		previous = f.store
+		f.file, f.offset, f.entries = file, 0, 0
+		f.store = make(map[string]fileItem)
+		if previous != nil {
+			defer f.notifyChanges(previous)
+		}
+	}
+
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// An incomplete line is a write in progress or interrupted by a crash.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("store %s is corrupted at offset %d: %v", f.path, f.offset, err)
+		}
+		f.apply(e, previous == nil)
+		f.offset += int64(len(line))
+	}
+}
+
+// apply changes the key of the entry and schedules its expiry. The watchers get the change if notify is true
+// and the entry changes more than the expiry, like with MemStore.
+func (f *FileStore) apply(e entry, notify bool) {
+	f.entries++
+	if timer, ok := f.timers[e.Key]; ok {
+		timer.Stop()
+		delete(f.timers, e.Key)
+	}
+
+	if e.Deleted {
+		delete(f.store, e.Key)
+		if notify {
+			reason := EventDelete
+			if e.Expired {
+				reason = EventExpire
+			}
+			f.watchers.notify(Event{Type: reason, Key: e.Key})
+		}
+		return
+	}
+
+	it := fileItem{value: e.Value}
+	if e.Expires != nil {
+		it.expires = *e.Expires
+		f.timers[e.Key] = time.AfterFunc(time.Until(it.expires), func() { f.expire(e.Key) })
+	}
+	f.store[e.Key] = it
+	if notify && !e.ExpiryOnly {
+		f.watchers.notify(Event{Type: EventSet, Key: e.Key, Value: decode(e.Value)})
+	}
+}
+
+// notifyChanges sends the differences between the keys in previous and the current keys to the watchers.
+func (f *FileStore) notifyChanges(previous map[string]fileItem) {
+	for k := range previous {
+		if _, ok := f.store[k]; !ok {
+			f.watchers.notify(Event{Type: EventDelete, Key: k})
+		}
+	}
+	for k, v := range f.store {
+		if old, ok := previous[k]; !ok || string(old.value) != string(v.value) {
+			f.watchers.notify(Event{Type: EventSet, Key: k, Value: decode(v.value)})
+		}
+	}
+}
+
+// expire deletes the key if it is still expired once the lock is held. Another process may have
+// deleted it or changed its expiry in the meantime.
+func (f *FileStore) expire(key string) {
+	f.withLock(true, func() error {
+		if it, ok := f.store[key]; ok && expired(it.expires) {
+			return f.append(entry{Key: key, Deleted: true, Expired: true})
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) write(key string, value interface{}, expires time.Time) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("could not encode the value of %s: %v", key, err)
+	}
+	e := entry{Key: key, Value: b}
+	if !expires.IsZero() {
+		e.Expires = &expires
+	}
+	return f.append(e)
+}
+
+func decode(raw json.RawMessage) interface{} {
+	var value interface{}
+	json.Unmarshal(raw, &value)
+	return value
+}
+
+// append writes the line after the last complete line, over any line left incomplete by a crash,
+// and syncs it to disk.
+func (f *FileStore) append(e entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if err := f.file.Truncate(f.offset); err != nil {
+		return err
+	}
+	if _, err := f.file.WriteAt(b, f.offset); err != nil {
+		return fmt.Errorf("could not write to store %s: %v", f.path, err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync store %s: %v", f.path, err)
+	}
+	f.offset += int64(len(b))
+	f.apply(e, true)
+
+	if f.entries > compactMinEntries && f.entries > 2*len(f.store) {
+		return f.compact()
+	}
+	return nil
+}
+
+// compact replaces the log with one line per key. The new log is synced before it replaces the old
+// one, so a crash leaves one of them in place.
+func (f *FileStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp makes the file private. The log keeps its mode, so the other users sharing it can still open it.
+	info, err := f.file.Stat()
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for k, v := range f.store {
+		e := entry{Key: k, Value: v.value}
+		if !v.expires.IsZero() {
+			e.Expires = &v.expires
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		n, err := w.Write(append(b, '\n'))
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not compact store %s: %v", f.path, err)
+	}
+	syncDir(filepath.Dir(f.path))
+
+	f.file.Close()
+	f.file, f.offset, f.entries = tmp, size, len(f.store)
+	return nil
+}
+
+// syncDir makes a rename in dir durable. It is not supported on every platform, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
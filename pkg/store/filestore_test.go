@@ -0,0 +1,181 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func newFileStore(t *testing.T, path string) *FileStore {
+	f, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	fileStore := newFileStore(t, path)
+
+	if err := fileStore.Set(KEY1, VALUE1); err != nil {
+		t.Error(err, "could not set key")
+	}
+	if err := fileStore.Set(KEY1, VALUE2); err != ErrKeyExists {
+		t.Error("did not return the key exists error")
+	}
+	if err := fileStore.Set(KEY2, map[string]int{"size": 2}); err != nil {
+		t.Error(err, "could not set key")
+	}
+	if err := fileStore.Update(KEY1, NEWVALUE); err != nil {
+		t.Error(err)
+	}
+	if err := fileStore.Update(NONEXISTINGKEY, NEWVALUE); err != ErrKeyDoesntExist {
+		t.Error("did not return key doesn't exist error")
+	}
+	if err := fileStore.Delete(KEY2); err != nil {
+		t.Error(err)
+	}
+	if err := fileStore.Set(KEY2, make(chan int)); err == nil {
+		t.Error("values that cannot be encoded should not be stored")
+	}
+	fileStore.Close()
+
+	reopened := newFileStore(t, path)
+	val, err := reopened.Get(KEY1)
+	if err != nil {
+		t.Error(err)
+	}
+	if val != NEWVALUE {
+		t.Errorf("expected %s after reopening the store, got %v", NEWVALUE, val)
+	}
+	if _, err := reopened.Get(KEY2); err != ErrKeyDoesntExist {
+		t.Error("deleted key was found after reopening the store")
+	}
+}
+
+func TestFileStoreIncompleteWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	fileStore := newFileStore(t, path)
+	if err := fileStore.Set(KEY1, VALUE1); err != nil {
+		t.Fatal(err)
+	}
+	fileStore.Close()
+
+	// A crash in the middle of a write leaves an incomplete line.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"key":"test-key2","val`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened := newFileStore(t, path)
+	if _, err := reopened.Get(KEY2); err != ErrKeyDoesntExist {
+		t.Error("incomplete write should be ignored")
+	}
+	if err := reopened.Set(KEY2, VALUE2); err != nil {
+		t.Error(err)
+	}
+	reopened.Close()
+
+	val, err := newFileStore(t, path).Get(KEY2)
+	if err != nil || val != VALUE2 {
+		t.Errorf("expected %s after the incomplete write was replaced, got %v, %v", VALUE2, val, err)
+	}
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	if err := os.WriteFile(path, []byte("not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path); err == nil {
+		t.Error("did not return an error for a corrupted store")
+	}
+}
+
+// TestFileStoreConcurrent uses one store per goroutine, like separate processes sharing the file.
+func TestFileStoreConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	const writers, keys = 4, 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		fileStore := newFileStore(t, path)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < keys; j++ {
+				if err := fileStore.Set(fmt.Sprintf("%d-%d", i, j), j); err != nil {
+					t.Error(err)
+				}
+				// Every writer updates the same key, which also compacts the log.
+				if err := fileStore.Set("shared", i); err != nil && err != ErrKeyExists {
+					t.Error(err)
+				} else if err == ErrKeyExists {
+					if err := fileStore.Update("shared", i); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	fileStore := newFileStore(t, path)
+	for i := 0; i < writers; i++ {
+		for j := 0; j < keys; j++ {
+			val, err := fileStore.Get(fmt.Sprintf("%d-%d", i, j))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val != float64(j) {
+				t.Fatalf("expected %d, got %v", j, val)
+			}
+		}
+	}
+	if fileStore.entries > 2*len(fileStore.store)+compactMinEntries {
+		t.Errorf("log was not compacted, %d entries for %d keys", fileStore.entries, len(fileStore.store))
+	}
+}
+
+func TestFileStoreCompactKeepsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+	path := filepath.Join(t.TempDir(), "store.log")
+	fileStore := newFileStore(t, path)
+	if err := fileStore.Set(KEY1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2*compactMinEntries; i++ {
+		if err := fileStore.Update(KEY1, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Fatal("the store was not compacted")
+	}
+	if after.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644 after compaction, got %v", after.Mode().Perm())
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package store
+
+import "os"
+
+// lockFile does nothing on platforms without file locks, where only one process should use a FileStore.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
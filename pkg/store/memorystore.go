@@ -1,42 +1,70 @@
-// Package store implements a simple key-value store.
 package store
 
 import (
-	"errors"
-	"log"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+type MemStore struct {
+	lock     *sync.Mutex
+	store    map[string]*item
+	watchers watchers
+}
+
+// item is a value and its expiry. The timer deletes the item once it expires.
+type item struct {
+	value   interface{}
+	expires time.Time
+	timer   *time.Timer
+}
+
 var (
-	ErrKeyExists      = errors.New("store: key already exists")
-	ErrKeyDoesntExist = errors.New("store: key does not exist")
+	memStore     *MemStore
+	memStoreLock sync.Mutex
 )
 
-type Store interface {
-	Set(key string, value interface{}) error
-	Get(key string) (interface{}, error)
-	Delete(key string) error
-	Update(key string, newValue interface{}) error
+// Option configures the store returned by NewMemStore.
+type Option func(*options)
+
+type options struct {
+	isolated bool
 }
 
-type MemStore struct {
-	lock  *sync.Mutex
-	store map[string]interface{}
+// Isolated makes NewMemStore return a new store with its own keys instead of the shared one.
+func Isolated() Option {
+	return func(o *options) {
+		o.isolated = true
+	}
 }
 
-var memStore *MemStore
+// NewMemStore returns the store shared by the process. Use Isolated for an independent store, or
+// WithNamespace to keep the keys of a user apart in a shared one.
+func NewMemStore(opts ...Option) Store {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.isolated {
+		return newMemStore()
+	}
 
-func NewMemStore() Store {
-	if memStore != nil {
-		return memStore
+	memStoreLock.Lock()
+	defer memStoreLock.Unlock()
+	if memStore == nil {
+		memStore = newMemStore()
 	}
+	return memStore
+}
 
-	memStore = &MemStore{
+func newMemStore() *MemStore {
+	return &MemStore{
 		lock:  new(sync.Mutex),
-		store: make(map[string]interface{}),
+		store: make(map[string]*item),
 	}
-
-	return memStore
 }
 
 // Set is used to set a value to a key.
@@ -44,10 +72,11 @@ func (m *MemStore) Set(key string, value interface{}) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if _, ok := m.store[key]; ok {
+	if _, ok := m.get(key); ok {
 		return ErrKeyExists
 	}
-	m.store[key] = value
+	m.store[key] = &item{value: value}
+	m.watchers.notify(Event{Type: EventSet, Key: key, Value: value})
 	return nil
 }
 
@@ -55,12 +84,12 @@ func (m *MemStore) Set(key string, value interface{}) error {
 func (m *MemStore) Get(key string) (interface{}, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	log.Println(m.store[key])
 
-	if _, ok := m.store[key]; !ok {
+	it, ok := m.get(key)
+	if !ok {
 		return nil, ErrKeyDoesntExist
 	}
-	return m.store[key], nil
+	return it.value, nil
 }
 
 // Delete removes the specified key and value.
@@ -68,10 +97,10 @@ func (m *MemStore) Delete(key string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if _, ok := m.store[key]; !ok {
+	if _, ok := m.get(key); !ok {
 		return ErrKeyDoesntExist
 	}
-	delete(m.store, key)
+	m.delete(key, EventDelete)
 	return nil
 }
 
@@ -80,9 +109,101 @@ func (m *MemStore) Update(key string, value interface{}) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if _, ok := m.store[key]; !ok {
+	it, ok := m.get(key)
+	if !ok {
+		return ErrKeyDoesntExist
+	}
+	it.value = value
+	m.watchers.notify(Event{Type: EventSet, Key: key, Value: value})
+	return nil
+}
+
+// List returns the keys that start with prefix and their values, sorted by key.
+func (m *MemStore) List(prefix string) ([]KeyValue, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	list := make([]KeyValue, 0)
+	for k := range m.store {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if it, ok := m.get(k); ok {
+			list = append(list, KeyValue{Key: k, Value: it.value})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list, nil
+}
+
+// CompareAndSwap replaces the value of the key with newValue if its value is equal to old.
+func (m *MemStore) CompareAndSwap(key string, old, newValue interface{}) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	it, ok := m.get(key)
+	if !ok {
+		return false, ErrKeyDoesntExist
+	}
+	if !reflect.DeepEqual(it.value, old) {
+		return false, nil
+	}
+	it.value = newValue
+	m.watchers.notify(Event{Type: EventSet, Key: key, Value: newValue})
+	return true, nil
+}
+
+// Expire deletes the key once ttl has passed. A ttl of 0 removes the expiry.
+func (m *MemStore) Expire(key string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	it, ok := m.get(key)
+	if !ok {
 		return ErrKeyDoesntExist
 	}
-	m.store[key] = value
+	if it.timer != nil {
+		it.timer.Stop()
+		it.timer = nil
+	}
+	it.expires = time.Time{}
+	if ttl <= 0 {
+		return nil
+	}
+
+	it.expires = time.Now().Add(ttl)
+	it.timer = time.AfterFunc(ttl, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		// The key is only deleted if it was not replaced, and its expiry was not moved, since.
+		if m.store[key] == it && expired(it.expires) {
+			m.delete(key, EventExpire)
+		}
+	})
 	return nil
 }
+
+// Watch returns the changes to the keys that start with prefix until ctx is done.
+func (m *MemStore) Watch(ctx context.Context, prefix string) <-chan Event {
+	return m.watchers.add(ctx, prefix)
+}
+
+// get returns the item of the key. Items whose timer has not run yet are deleted once they expire.
+func (m *MemStore) get(key string) (*item, bool) {
+	it, ok := m.store[key]
+	if ok && expired(it.expires) {
+		m.delete(key, EventExpire)
+		return nil, false
+	}
+	return it, ok
+}
+
+func (m *MemStore) delete(key string, reason EventType) {
+	if it := m.store[key]; it.timer != nil {
+		it.timer.Stop()
+	}
+	delete(m.store, key)
+	m.watchers.notify(Event{Type: reason, Key: key})
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// namespace is a view of a store that keeps its keys under a prefix.
+type namespace struct {
+	store  Store
+	prefix string
+}
+
+// WithNamespace returns a view of s whose keys are stored as "name/key". The view only sees
+// its own keys, and its List and Watch return them without the prefix. Namespaces can be nested.
+func WithNamespace(s Store, name string) Store {
+	return &namespace{store: s, prefix: name + "/"}
+}
+
+func (n *namespace) Set(key string, value interface{}) error {
+	return n.store.Set(n.prefix+key, value)
+}
+
+func (n *namespace) Get(key string) (interface{}, error) {
+	return n.store.Get(n.prefix + key)
+}
+
+func (n *namespace) Delete(key string) error {
+	return n.store.Delete(n.prefix + key)
+}
+
+func (n *namespace) Update(key string, newValue interface{}) error {
+	return n.store.Update(n.prefix+key, newValue)
+}
+
+func (n *namespace) List(prefix string) ([]KeyValue, error) {
+	list, err := n.store.List(n.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Key = strings.TrimPrefix(list[i].Key, n.prefix)
+	}
+	return list, nil
+}
+
+func (n *namespace) CompareAndSwap(key string, old, newValue interface{}) (bool, error) {
+	return n.store.CompareAndSwap(n.prefix+key, old, newValue)
+}
+
+func (n *namespace) Expire(key string, ttl time.Duration) error {
+	return n.store.Expire(n.prefix+key, ttl)
+}
+
+func (n *namespace) Watch(ctx context.Context, prefix string) <-chan Event {
+	events := n.store.Watch(ctx, n.prefix+prefix)
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for e := range events {
+			e.Key = strings.TrimPrefix(e.Key, n.prefix)
+			select {
+			case out <- e:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,60 @@
+// Package store implements a simple key-value store.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrKeyExists      = errors.New("store: key already exists")
+	ErrKeyDoesntExist = errors.New("store: key does not exist")
+)
+
+type Store interface {
+	Set(key string, value interface{}) error
+	Get(key string) (interface{}, error)
+	Delete(key string) error
+	Update(key string, newValue interface{}) error
+	// List returns the keys that start with prefix and their values, sorted by key.
+	List(prefix string) ([]KeyValue, error)
+	// CompareAndSwap replaces the value of the key with newValue if its value is equal to old, as
+	// compared by reflect.DeepEqual, and reports whether it did.
+	CompareAndSwap(key string, old, newValue interface{}) (bool, error)
+	// Expire deletes the key once ttl has passed. A ttl of 0 removes the expiry. Updates keep the expiry.
+	Expire(key string, ttl time.Duration) error
+	// Watch returns the changes to the keys that start with prefix, in the order they happened, until
+	// ctx is done. The channel is closed then. Changes are queued while the receiver is busy, so
+	// a slow receiver does not block the store.
+	Watch(ctx context.Context, prefix string) <-chan Event
+}
+
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// EventType is the kind of change in an Event.
+type EventType string
+
+const (
+	// EventSet is sent when a key is set, updated or swapped.
+	EventSet EventType = "set"
+	// EventDelete is sent when a key is deleted.
+	EventDelete EventType = "delete"
+	// EventExpire is sent when a key is deleted because its expiry passed.
+	EventExpire EventType = "expire"
+)
+
+// Event is a change to a key. Value is the new value of the key for EventSet.
+type Event struct {
+	Type  EventType
+	Key   string
+	Value interface{}
+}
+
+// expired returns true if the expiry is set and has passed.
+func expired(expires time.Time) bool {
+	return !expires.IsZero() && !time.Now().Before(expires)
+}
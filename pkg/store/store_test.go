@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemStoreAPI(t *testing.T) {
+	testStore(t, NewMemStore(Isolated()), "mem/")
+}
+
+func TestFileStoreAPI(t *testing.T) {
+	testStore(t, newFileStore(t, filepath.Join(t.TempDir(), "store.log")), "file/")
+}
+
+func TestNamespaceAPI(t *testing.T) {
+	s := newFileStore(t, filepath.Join(t.TempDir(), "store.log"))
+	testStore(t, WithNamespace(WithNamespace(s, "pipeline"), "run-1"), "")
+}
+
+func TestNamespace(t *testing.T) {
+	s := NewMemStore(Isolated())
+	if s == NewMemStore() || s == NewMemStore(Isolated()) {
+		t.Fatal("isolated store is shared")
+	}
+
+	run1, run2 := WithNamespace(s, "run-1"), WithNamespace(s, "run-2")
+	if err := run1.Set(KEY1, VALUE1); err != nil {
+		t.Fatal(err)
+	}
+	if err := run2.Set(KEY1, VALUE2); err != nil {
+		t.Error("namespaces share keys")
+	}
+	if val, err := run1.Get(KEY1); err != nil || val != VALUE1 {
+		t.Errorf("expected %s, got %v, %v", VALUE1, val, err)
+	}
+	if val, err := s.Get("run-2/" + KEY1); err != nil || val != VALUE2 {
+		t.Errorf("expected %s, got %v, %v", VALUE2, val, err)
+	}
+	list, err := run2.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []KeyValue{{Key: KEY1, Value: VALUE2}}; !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected %v, got %v", expected, list)
+	}
+}
+
+// testStore checks List, CompareAndSwap, Expire and Watch on keys that start with prefix.
+func testStore(t *testing.T, s Store, prefix string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Watch(ctx, prefix+"jobs/")
+
+	for _, k := range []string{"jobs/b", "jobs/a", "runs/1"} {
+		if err := s.Set(prefix+k, "pending"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := s.List(prefix + "jobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []KeyValue{{Key: prefix + "jobs/a", Value: "pending"}, {Key: prefix + "jobs/b", Value: "pending"}}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected %v, got %v", expected, list)
+	}
+
+	swapped, err := s.CompareAndSwap(prefix+"jobs/a", "pending", "running")
+	if err != nil || !swapped {
+		t.Errorf("expected the value to be swapped, got %v, %v", swapped, err)
+	}
+	swapped, err = s.CompareAndSwap(prefix+"jobs/a", "pending", "passed")
+	if err != nil || swapped {
+		t.Errorf("expected the value not to be swapped, got %v, %v", swapped, err)
+	}
+	if _, err := s.CompareAndSwap(prefix+"jobs/c", nil, "passed"); err != ErrKeyDoesntExist {
+		t.Error("did not return key doesn't exist error")
+	}
+
+	if err := s.Delete(prefix + "jobs/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Expire(prefix+"jobs/a", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(prefix+"jobs/a", "passed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Expire(prefix+"runs/1", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Expire(prefix+"runs/1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Event{
+		{Type: EventSet, Key: prefix + "jobs/b", Value: "pending"},
+		{Type: EventSet, Key: prefix + "jobs/a", Value: "pending"},
+		{Type: EventSet, Key: prefix + "jobs/a", Value: "running"},
+		{Type: EventDelete, Key: prefix + "jobs/b"},
+		// Setting the expiry does not change the value, so it sends no event.
+		{Type: EventSet, Key: prefix + "jobs/a", Value: "passed"},
+		{Type: EventExpire, Key: prefix + "jobs/a"},
+	}
+	for _, v := range want {
+		select {
+		case e := <-events:
+			if !reflect.DeepEqual(e, v) {
+				t.Errorf("expected event %v, got %v", v, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive event %v", v)
+		}
+	}
+
+	if _, err := s.Get(prefix + "jobs/a"); err != ErrKeyDoesntExist {
+		t.Error("expired key was found")
+	}
+	if _, err := s.Get(prefix + "runs/1"); err != nil {
+		t.Error("key without expiry was deleted")
+	}
+	if err := s.Set(prefix+"jobs/a", "pending"); err != nil {
+		t.Error("expired key could not be set again")
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+// TestCompareAndSwapConcurrent increments a counter from several goroutines. Run it with -race.
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	mem := NewMemStore(Isolated())
+	stores := map[string]func() Store{
+		"mem":  func() Store { return mem },
+		"file": func() Store { return newFileStore(t, path) },
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			key := "counter/" + name
+			if err := newStore().Set(key, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			const goroutines, increments = 8, 25
+			var wg sync.WaitGroup
+			for i := 0; i < goroutines; i++ {
+				s := newStore()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < increments; {
+						v, err := s.Get(key)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						n := toInt(v)
+						swapped, err := s.CompareAndSwap(key, v, n+1)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if swapped {
+							j++
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			v, err := newStore().Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if toInt(v) != goroutines*increments {
+				t.Errorf("expected %d, got %v", goroutines*increments, v)
+			}
+		})
+	}
+}
+
+// toInt converts the numbers of both stores, which are float64 once decoded from JSON.
+func toInt(v interface{}) int {
+	if f, ok := v.(float64); ok {
+		return int(f)
+	}
+	return v.(int)
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// watchers sends the events of a store to the channels returned by Watch.
+type watchers struct {
+	lock sync.Mutex
+	list []*watcher
+}
+
+// watcher queues the events until the goroutine of the watch sends them.
+type watcher struct {
+	prefix string
+	lock   sync.Mutex
+	queue  []Event
+	ready  chan struct{}
+}
+
+func (w *watchers) add(ctx context.Context, prefix string) <-chan Event {
+	wt := &watcher{prefix: prefix, ready: make(chan struct{}, 1)}
+	w.lock.Lock()
+	w.list = append(w.list, wt)
+	w.lock.Unlock()
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer w.remove(wt)
+		for {
+			wt.lock.Lock()
+			queue := wt.queue
+			wt.queue = nil
+			wt.lock.Unlock()
+
+			for _, e := range queue {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-wt.ready:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (w *watchers) remove(wt *watcher) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i, v := range w.list {
+		if v == wt {
+			w.list = append(w.list[:i], w.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify queues the event for the watchers of the key. It does not block.
+func (w *watchers) notify(e Event) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, wt := range w.list {
+		if !strings.HasPrefix(e.Key, wt.prefix) {
+			continue
+		}
+		wt.lock.Lock()
+		wt.queue = append(wt.queue, e)
+		wt.lock.Unlock()
+		select {
+		case wt.ready <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -148,6 +148,53 @@ func CompressTar(path, outputPath string) error {
 	})
 }
 
+// CompressTarRelative takes a path relative to baseDir and creates a .tar file at the outputPath location.
+// The names in the tar file are relative to baseDir.
+func CompressTarRelative(baseDir, path, outputPath string) error {
+	tarFile, err := os.Create(filepath.Clean(outputPath))
+	if err != nil {
+		return fmt.Errorf("could not create tar file %s: %v", outputPath, err)
+	}
+	defer tarFile.Close()
+
+	tw := tar.NewWriter(tarFile)
+	defer tw.Close()
+
+	return filepath.Walk(filepath.Join(baseDir, path), func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return fmt.Errorf("could not create tar file %s: %v", path, err)
+		}
+
+		header, err := tar.FileInfoHeader(info, path)
+		if err != nil {
+			return fmt.Errorf("could not create tar file %s: %v", path, err)
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("could not create tar file %s: %v", path, err)
+		}
+
+		if info.Mode().IsRegular() {
+			data, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("could not open file %s: %v", path, err)
+			}
+			if _, err := io.Copy(tw, data); err != nil {
+				return fmt.Errorf("could not copy tar contents for file %s: %v", path, err)
+			}
+			if err := data.Close(); err != nil {
+				return fmt.Errorf("could not close file %s: %v", data.Name(), err)
+			}
+		}
+		return nil
+	})
+}
+
 // DecompressTar takes a location to a .tar file and a base path and decompresses the contents wrt the base path.
 func DecompressTar(tarPath, baseDir string) error {
 	tarFile, err := os.Open(filepath.Clean(tarPath))
@@ -156,13 +203,18 @@ func DecompressTar(tarPath, baseDir string) error {
 	}
 	defer tarFile.Close()
 
-	tr := tar.NewReader(tarFile)
+	return ExtractTar(tarFile, baseDir)
+}
+
+// ExtractTar extracts the tar stream r wrt the base path.
+func ExtractTar(r io.Reader, baseDir string) error {
+	tr := tar.NewReader(r)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
 			return nil
 		} else if err != nil {
-			return fmt.Errorf("could not read tar header %s: %v", header.Name, err)
+			return fmt.Errorf("could not read tar header: %v", err)
 		}
 
 		target, err := sanitizeArchivePath(baseDir, header.Name)
@@ -177,7 +229,8 @@ func DecompressTar(tarPath, baseDir string) error {
 				}
 			}
 		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, 0644)
+			// Keep the mode, so extracted binaries can be run.
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(header.Mode).Perm())
 			if err != nil {
 				return fmt.Errorf("could not open file %s: %v", target, err)
 			}
@@ -190,6 +243,38 @@ func DecompressTar(tarPath, baseDir string) error {
 	}
 }
 
+// ChownTar returns a reader with the contents of the tar stream r where every entry is owned by uid and gid.
+// The reader must be closed, which stops the goroutine that writes it if it was not read to the end.
+func ChownTar(r io.Reader, uid, gid int) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pw.CloseWithError(fmt.Errorf("could not read tar header: %v", err))
+				return
+			}
+
+			header.Uid, header.Gid = uid, gid
+			header.Uname, header.Gname = "", ""
+			if err := tw.WriteHeader(header); err != nil {
+				pw.CloseWithError(fmt.Errorf("could not write tar header %s: %v", header.Name, err))
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(fmt.Errorf("could not copy tar contents for file %s: %v", header.Name, err))
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
 // TarCopy uses tar archive to copy src to dst to preserve the folder structure.
 func TarCopy(src, dst, tempDir string) error {
 	f, err := os.CreateTemp(tempDir, "tarcopy-*.tar.gzip")
@@ -211,6 +296,63 @@ func TarCopy(src, dst, tempDir string) error {
 	return nil
 }
 
+// CopyDir copies src into dst preserving the file modes and symlinks.
+// Like the tar based copies, the relative path of src is kept inside dst.
+func CopyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target, err := sanitizeArchivePath(dst, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("could not create dir %s: %v", target, err)
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("could not read link %s: %v", path, err)
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return fmt.Errorf("could not create link %s: %v", target, err)
+			}
+		case info.Mode().IsRegular():
+			if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return fmt.Errorf("could not open file %s: %v", src, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("could not create dir %s: %v", filepath.Dir(dst), err)
+	}
+
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return fmt.Errorf("could not create file %s: %v", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("could not copy %s to %s: %v", src, dst, err)
+	}
+	return out.Close()
+}
+
 func sanitizeArchivePath(dir, target string) (string, error) {
 	full := filepath.Join(dir, target)
 	if strings.HasPrefix(full, filepath.Clean(dir)) {
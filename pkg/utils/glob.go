@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob reports whether the slash separated name matches the pattern. The pattern uses the syntax
+// of path.Match for every segment, and a ** segment matches any number of segments, including none.
+// Malformed patterns match nothing.
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ValidateGlob returns an error if the pattern is malformed.
+func ValidateGlob(pattern string) error {
+	for _, v := range strings.Split(pattern, "/") {
+		if _, err := path.Match(v, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GlobBase returns the leading directories of the pattern that do not contain wildcards. It returns
+// the pattern itself when it has no wildcards.
+func GlobBase(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, v := range segments {
+		if strings.ContainsAny(v, `*?[\`) {
+			base := strings.Join(segments[:i], "/")
+			switch {
+			case len(base) > 0:
+				return base
+			case strings.HasPrefix(pattern, "/"):
+				return "/"
+			default:
+				return "."
+			}
+		}
+	}
+	return pattern
+}
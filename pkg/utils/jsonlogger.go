@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Streams of a LogRecord.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	// StreamSystem is used for the messages and events of dot itself.
+	StreamSystem = "system"
+)
+
+// LogRecord is a line of the JSON log.
+type LogRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Job       string    `json:"job,omitempty"`
+	Stream    string    `json:"stream"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	// Event names the lifecycle event of system records, like image_pull_start.
+	Event   string `json:"event,omitempty"`
+	Message string `json:"message"`
+}
+
+// JSONLog writes one JSON object per line for every record. It is safe for concurrent use.
+type JSONLog struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewJSONLog(w io.Writer) *JSONLog {
+	return &JSONLog{enc: json.NewEncoder(w)}
+}
+
+// Log writes the record, setting its timestamp to the current time if it is not set.
+func (l *JSONLog) Log(r LogRecord) error {
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now().UTC()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.enc.Encode(r)
+}
+
+// Writer returns a writer that logs every line written to it as the message of a copy of r. Lines
+// longer than 64KiB are split, and an incomplete last line is logged when the writer is closed.
+func (l *JSONLog) Writer(r LogRecord) io.WriteCloser {
+	return &jsonWriter{log: l, record: r}
+}
+
+type jsonWriter struct {
+	log    *JSONLog
+	record LogRecord
+	lock   sync.Mutex
+	buf    []byte
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		line, rest, ok := nextLine(w.buf)
+		if !ok {
+			return len(p), nil
+		}
+		w.buf = rest
+		if err := w.logLine(bytes.TrimSuffix(line, []byte("\r"))); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (w *jsonWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.buf
+	w.buf = nil
+	return w.logLine(line)
+}
+
+func (w *jsonWriter) logLine(line []byte) error {
+	r := w.record
+	r.Message = string(line)
+	return w.log.Log(r)
+}
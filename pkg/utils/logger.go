@@ -1,10 +1,16 @@
 package utils
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"os"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 )
 
 var colors = []color.Attribute{color.FgYellow, color.FgGreen, color.FgRed, color.FgWhite, color.FgMagenta}
@@ -12,35 +18,152 @@ var index = -1
 
 var l sync.Mutex
 
+// writeLock makes the lines of the loggers reach their writer whole, so the lines of concurrent jobs
+// do not interleave.
+var writeLock sync.Mutex
+
 const MaxNameLength = 20
 
-// ColorLogger provides an io.Writer that can output in color.
+// maxLineLength is the length after which a line without a newline is written anyway.
+const maxLineLength = 64 * 1024
+
+// nextLine returns the first line of buf, without its newline, and the bytes after it. ok is false
+// while buf holds no complete line. Lines longer than maxLineLength are split at the last rune that
+// starts before the limit, so the parts stay valid UTF-8.
+func nextLine(buf []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(buf, '\n')
+	if i >= 0 && i <= maxLineLength {
+		return buf[:i], buf[i+1:], true
+	}
+	if i < 0 && len(buf) <= maxLineLength {
+		return nil, buf, false
+	}
+	// Long lines are split, so a job that never writes a newline does not fill the memory.
+	n := maxLineLength
+	for j := n; j > maxLineLength-utf8.UTFMax && j > 0; j-- {
+		if utf8.RuneStart(buf[j]) {
+			n = j
+			break
+		}
+	}
+	return buf[:n], buf[n:], true
+}
+
+// Timestamps selects the time printed before every line.
+type Timestamps string
+
+const (
+	TimestampsNone Timestamps = "none"
+	// TimestampsElapsed prints the time since the start given to WithTimestamps.
+	TimestampsElapsed Timestamps = "elapsed"
+	// TimestampsWall prints the time of day.
+	TimestampsWall Timestamps = "wall"
+)
+
+// ColorLogger provides an io.Writer that prefixes every line with a name, in color when the writer is a
+// terminal and NO_COLOR is not set. Lines are buffered until they are complete, and Close writes the
+// last line if it has no newline.
 type ColorLogger struct {
-	name   string
-	writer io.Writer
-	c      color.Attribute
+	name       string
+	writer     io.Writer
+	c          *color.Color
+	timestamps Timestamps
+	start      time.Time
+	lock       sync.Mutex
+	buf        []byte
 }
 
-func NewColorLogger(name string, writer io.Writer, newColor bool) io.Writer {
-	if newColor {
-		l.Lock()
-		defer l.Unlock()
+func NewColorLogger(name string, writer io.Writer, newColor bool) *ColorLogger {
+	l.Lock()
+	if newColor || index < 0 {
 		index = (index + 1) % len(colors)
 	}
+	c := color.New(colors[index])
+	l.Unlock()
 
 	if len(name) > MaxNameLength {
 		name = name[:MaxNameLength-3] + "..."
 	}
 
+	if useColor(writer) {
+		c.EnableColor()
+	} else {
+		c.DisableColor()
+	}
+
 	return &ColorLogger{
-		name:   name,
-		writer: writer,
-		c:      colors[index],
+		name:       name,
+		writer:     writer,
+		c:          c,
+		timestamps: TimestampsNone,
 	}
 }
 
+// WithTimestamps prints the time before every line. Elapsed times are measured from start.
+func (c *ColorLogger) WithTimestamps(timestamps Timestamps, start time.Time) *ColorLogger {
+	c.timestamps = timestamps
+	c.start = start
+	return c
+}
+
 func (c *ColorLogger) Write(p []byte) (int, error) {
-	out := color.New(c.c)
-	out.Print(c.name, " | ")
-	return out.Fprintf(c.writer, "%s", p)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.buf = append(c.buf, p...)
+	for {
+		line, rest, ok := nextLine(c.buf)
+		if !ok {
+			return len(p), nil
+		}
+		c.buf = rest
+		if err := c.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Close writes the buffered partial line, followed by a newline.
+func (c *ColorLogger) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.buf) == 0 {
+		return nil
+	}
+	line := c.buf
+	c.buf = nil
+	return c.writeLine(line)
+}
+
+// writeLine writes the prefix, the line and a newline in a single Write.
+func (c *ColorLogger) writeLine(line []byte) error {
+	var prefix string
+	switch c.timestamps {
+	case TimestampsElapsed:
+		prefix = fmt.Sprintf("%9.3fs ", time.Since(c.start).Seconds())
+	case TimestampsWall:
+		prefix = time.Now().Format("15:04:05.000") + " "
+	}
+	prefix += c.name + " | "
+
+	// The newline is written after the color is reset.
+	out := c.c.Sprint(prefix+string(line)) + "\n"
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	_, err := io.WriteString(c.writer, out)
+	return err
+}
+
+// useColor returns true if w is a terminal and the NO_COLOR environment variable is not set.
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
 }
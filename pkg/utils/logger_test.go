@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColorLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewColorLogger("job", &b, true)
+
+	for _, v := range []string{"one\ntw", "o\n", "thr", "ee\nfour"} {
+		n, err := logger.Write([]byte(v))
+		assert.NoError(t, err)
+		assert.Equal(t, len(v), n)
+	}
+	assert.Equal(t, "job | one\njob | two\njob | three\n", b.String(), "partial lines should be buffered")
+
+	assert.NoError(t, logger.Close())
+	assert.Equal(t, "job | one\njob | two\njob | three\njob | four\n", b.String(), "close should write the partial line")
+}
+
+func TestColorLoggerLongLine(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewColorLogger("job", &b, true)
+
+	_, err := logger.Write(bytes.Repeat([]byte("a"), maxLineLength+10))
+	assert.NoError(t, err)
+	assert.NoError(t, logger.Close())
+	assert.Equal(t, 2, bytes.Count(b.Bytes(), []byte("job | ")))
+}
+
+func TestColorLoggerMaxLine(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewColorLogger("job", &b, true)
+
+	line := strings.Repeat("a", maxLineLength)
+	_, err := logger.Write([]byte(line + "\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, logger.Close())
+	assert.Equal(t, "job | "+line+"\n", b.String(), "a line of the maximum length should not be split")
+
+	b.Reset()
+	// The limit falls in the middle of the last é.
+	line = strings.Repeat("a", maxLineLength-1) + "é"
+	_, err = logger.Write([]byte(line + "\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, logger.Close())
+	assert.Equal(t, "job | "+line[:maxLineLength-1]+"\njob | é\n", b.String(), "lines should be split between runes")
+}
+
+func TestColorLoggerTimestamps(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewColorLogger("job", &b, true).WithTimestamps(TimestampsElapsed, time.Now().Add(-1500*time.Millisecond))
+	_, err := logger.Write([]byte("line\n"))
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^ +1\.5\d\ds job \| line\n$`), b.String())
+
+	b.Reset()
+	logger = NewColorLogger("job", &b, true).WithTimestamps(TimestampsWall, time.Time{})
+	_, err = logger.Write([]byte("line\n"))
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d{3} job \| line\n$`), b.String())
+}
+
+func TestJSONLogWriterLongLine(t *testing.T) {
+	var b bytes.Buffer
+	w := NewJSONLog(&b).Writer(LogRecord{Job: "job", Stream: StreamStdout})
+
+	_, err := w.Write(bytes.Repeat([]byte("a"), maxLineLength+10))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(b.Bytes(), []byte("\n")), "a line longer than the limit should be logged without waiting for its end")
+	_, err = w.Write([]byte("b\r\nc"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	var messages []string
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var r LogRecord
+		assert.NoError(t, dec.Decode(&r))
+		assert.Equal(t, "job", r.Job)
+		messages = append(messages, r.Message)
+	}
+	assert.Equal(t, []string{strings.Repeat("a", maxLineLength), "aaaaaaaaaab", "c"}, messages)
+
+	b.Reset()
+	w = NewJSONLog(&b).Writer(LogRecord{Job: "job", Stream: StreamStdout})
+	exact := strings.Repeat("a", maxLineLength)
+	split := strings.Repeat("a", maxLineLength-1) + "é"
+	_, err = w.Write([]byte(exact + "\n" + split + "\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	messages = nil
+	dec = json.NewDecoder(&b)
+	for dec.More() {
+		var r LogRecord
+		assert.NoError(t, dec.Decode(&r))
+		messages = append(messages, r.Message)
+	}
+	assert.Equal(t, []string{exact, split[:maxLineLength-1], "é"}, messages, "lines of the maximum length should not be split, and splits should keep runes whole")
+}